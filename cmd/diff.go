@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/MyoMyatMin/gitops-controller/internal/config"
+	"github.com/MyoMyatMin/gitops-controller/internal/k8s"
+	"github.com/MyoMyatMin/gitops-controller/internal/log"
+	"github.com/MyoMyatMin/gitops-controller/internal/sync"
+	"github.com/sirupsen/logrus"
+)
+
+// Exit codes of the diff subcommand, following diff(1).
+const (
+	diffExitClean   = 0
+	diffExitChanged = 1
+	diffExitError   = 2
+)
+
+const diffUsage = `Usage: gitops-controller diff [flags] <repository> [revision]
+
+Previews what syncing <repository> at [revision], or at its tracked ref, would
+change, using server-side dry-run applies. Exits 0 without changes, 1 with
+changes and 2 on errors.
+
+Flags:
+`
+
+// runDiff implements the diff subcommand. The repository is cloned into
+// memory so a running controller's cache is never touched.
+func runDiff(args []string) int {
+	flags := flag.NewFlagSet("diff", flag.ContinueOnError)
+	verbose := flags.Bool("v", false, "log progress to stderr")
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), diffUsage)
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return diffExitClean
+		}
+		return diffExitError
+	}
+	if flags.NArg() < 1 || flags.NArg() > 2 {
+		flags.Usage()
+		return diffExitError
+	}
+	name, revision := flags.Arg(0), flags.Arg(1)
+
+	// Logs go to stderr so that stdout carries only the diff.
+	log.Logger.SetOutput(os.Stderr)
+	if !*verbose {
+		log.Logger.SetLevel(logrus.WarnLevel)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	result, err := diffRepository(ctx, name, revision)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "diff: %v\n", err)
+		return diffExitError
+	}
+
+	if err := result.WriteUnified(os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "diff: %v\n", err)
+		return diffExitError
+	}
+
+	switch {
+	case len(result.Errors) > 0:
+		return diffExitError
+	case result.HasChanges():
+		return diffExitChanged
+	}
+	return diffExitClean
+}
+
+func diffRepository(ctx context.Context, name, revision string) (*sync.DiffResult, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, fmt.Errorf("error loading configuration: %w", err)
+	}
+
+	var repoCfg *config.RepositoryConfig
+	for i := range cfg.Repositories {
+		if cfg.Repositories[i].Name == name {
+			repoCfg = &cfg.Repositories[i]
+		}
+	}
+	if repoCfg == nil {
+		return nil, fmt.Errorf("repository %q is not configured", name)
+	}
+
+	k8sClient, err := k8s.NewClient(cfg.Kubernetes)
+	if err != nil {
+		return nil, fmt.Errorf("error creating Kubernetes client: %w", err)
+	}
+
+	gitCfg := cfg.Git
+	gitCfg.InMemory = true
+	gitCfg.Depth = 0
+	repo, err := newRepository(gitCfg, *repoCfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := repo.Clone(ctx); err != nil {
+		return nil, fmt.Errorf("error cloning %s: %w", name, err)
+	}
+
+	return sync.NewEngine(ctx, repo, k8sClient, *repoCfg).Diff(ctx, revision)
+}
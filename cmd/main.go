@@ -1,11 +1,14 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/MyoMyatMin/gitops-controller/internal/api"
 	"github.com/MyoMyatMin/gitops-controller/internal/config"
@@ -13,13 +16,22 @@ import (
 
 	"github.com/MyoMyatMin/gitops-controller/internal/git"
 	"github.com/MyoMyatMin/gitops-controller/internal/k8s"
+	"github.com/MyoMyatMin/gitops-controller/internal/leader"
 	"github.com/MyoMyatMin/gitops-controller/internal/sync"
 	"github.com/MyoMyatMin/gitops-controller/pkg/manifest"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 )
 
+// followerRefreshInterval is how often a follower retries failed clones and
+// reloads the status the leader saved.
+const followerRefreshInterval = 30 * time.Second
+
 func main() {
 
+	if len(os.Args) > 1 && os.Args[1] == "diff" {
+		os.Exit(runDiff(os.Args[2:]))
+	}
+
 	log.Init()
 	log.Info("GitOps Controller starting")
 
@@ -28,69 +40,185 @@ func main() {
 		log.Fatalf("Error loading configuration: %v", err)
 	}
 
-	k8sClient, err := k8s.NewClient(cfg.Kubernetes.Kubeconfig)
+	// Cancelled on SIGINT/SIGTERM, which aborts initial clones and in-flight syncs.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	k8sClient, err := k8s.NewClient(cfg.Kubernetes)
 	if err != nil {
 		log.Fatalf("Error creating Kubernetes client: %v", err)
 	}
 
 	var engines []*sync.Engine
-	var pollers []*sync.Poller
+	var intervals []time.Duration
+	var namespaces []string
 
+	var repoNames []string
 	for _, repoCfg := range cfg.Repositories {
-		log.Infof("Initializing repository: %s", repoCfg.Name)
+		repoNames = append(repoNames, repoCfg.Name)
+	}
+	cacheStopCh := make(chan struct{})
+	if !cfg.Git.InMemory {
+		go git.StartCacheCleanup(cfg.Git.CacheDir, repoNames, cfg.Git.GCInterval, cacheStopCh)
+	}
 
-		localPath := filepath.Join("/tmp/gitops-repos", repoCfg.Name)
+	for _, repoCfg := range cfg.Repositories {
+		log.Infof("Initializing repository: %s", repoCfg.Name)
 
-		repo := &git.Repository{
-			URL:       repoCfg.URL,
-			Branch:    repoCfg.Branch,
-			LocalPath: localPath,
+		repo, err := newRepository(cfg.Git, repoCfg)
+		if err != nil {
+			log.Errorf("Invalid settings for repo %s, skipping: %v", repoCfg.Name, err)
+			continue
 		}
 
-		os.RemoveAll(repo.LocalPath)
-		if err := repo.Clone(); err != nil {
-			log.Errorf("Failed to clone repo %s: %v", repoCfg.Name, err)
-		}
+		// Repositories are cloned by the pollers, or by followEngines on a
+		// follower, so the probes are served while a clone is slow.
+		engine := sync.NewEngine(ctx, repo, k8sClient, repoCfg)
+		engines = append(engines, engine)
+		intervals = append(intervals, repoCfg.Interval)
+		namespaces = append(namespaces, repoCfg.Namespace)
+	}
 
-		if err := ensureNamespace(k8sClient, repoCfg.Namespace); err != nil {
-			if !strings.Contains(err.Error(), "already exists") {
-				log.Errorf("Error ensuring namespace %s: %v", repoCfg.Namespace, err)
+	// runPollers polls every repository until ctx is cancelled, i.e. for as
+	// long as this replica leads.
+	runPollers := func(ctx context.Context) {
+		for _, namespace := range namespaces {
+			if err := ensureNamespace(ctx, k8sClient, namespace); err != nil {
+				if !strings.Contains(err.Error(), "already exists") {
+					log.Errorf("Error ensuring namespace %s: %v", namespace, err)
+				}
 			}
 		}
 
-		engine := sync.NewEngine(repo, k8sClient, repoCfg.Namespace, repoCfg.Path)
-		engines = append(engines, engine)
-
-		poller := sync.NewPoller(engine, repoCfg.Interval)
-		pollers = append(pollers, poller)
+		var pollers []*sync.Poller
+		for i, engine := range engines {
+			engine.SetContext(ctx)
+			poller := sync.NewPoller(engine, intervals[i])
+			pollers = append(pollers, poller)
+			go poller.Start(ctx)
+		}
+		<-ctx.Done()
+		for _, p := range pollers {
+			p.Stop()
+		}
+	}
 
-		go poller.Start()
+	var leadership *leader.Leadership
+	pollersDone := make(chan struct{})
+	if cfg.LeaderElection.Enabled {
+		leadership = leader.New()
+		go func() {
+			leadership.Run(ctx, k8sClient, cfg.LeaderElection, runPollers)
+			close(pollersDone)
+		}()
+		go followEngines(ctx, leadership, engines)
+	} else {
+		leadership = leader.Always(ctx)
+		go func() {
+			runPollers(ctx)
+			close(pollersDone)
+		}()
 	}
 
+	var webhookServer *api.WebhookServer
+	serverDone := make(chan struct{})
 	if cfg.Webhook.Enabled {
-		webhookServer := api.NewWebhookServer(engines, cfg.Webhook.Secret)
+		webhookServer = api.NewWebhookServer(engines, k8sClient, leadership, cfg)
 		go func() {
-			if err := webhookServer.Start(cfg.Webhook.Port); err != nil {
+			defer close(serverDone)
+			if err := webhookServer.Start(ctx, cfg.Webhook.Port); err != nil {
 				log.Fatalf("Webhook server failed: %v", err)
 			}
 		}()
 		log.Infof("Webhook server enabled on port %d", cfg.Webhook.Port)
+	} else {
+		close(serverDone)
 	}
-	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
-	<-sigCh
+	<-ctx.Done()
 
 	log.Info("Shutting down...")
 
-	// Stop all pollers
-	for _, p := range pollers {
-		p.Stop()
+	// Pollers and the server stop when ctx is cancelled; wait for them and
+	// for any sync they started, which may still be saving its inventory.
+	<-pollersDone
+	<-serverDone
+	if webhookServer != nil {
+		webhookServer.Wait()
 	}
+	for _, engine := range engines {
+		engine.Wait()
+	}
+	close(cacheStopCh)
 
 	log.Info("Main application shut down gracefully.")
 }
 
-func ensureNamespace(c *k8s.Client, name string) error {
+// followEngines clones the repositories on a follower and keeps the status it
+// reports from the inventories current until ctx is cancelled. Each
+// repository is cloned in its own goroutine so one slow clone does not hold up
+// the others.
+func followEngines(ctx context.Context, leadership *leader.Leadership, engines []*sync.Engine) {
+	for _, engine := range engines {
+		go func() {
+			ticker := time.NewTicker(followerRefreshInterval)
+			defer ticker.Stop()
+
+			for {
+				if !leadership.IsLeader() {
+					if err := engine.EnsureCloned(ctx); err != nil {
+						log.Errorf("Error cloning repository %s: %v", engine.Name(), err)
+					}
+					if err := engine.LoadState(ctx); err != nil {
+						log.Errorf("Error loading state of %s: %v", engine.Name(), err)
+					}
+				}
+
+				select {
+				case <-ticker.C:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+}
+
+func newRepository(gitCfg config.GitConfig, repoCfg config.RepositoryConfig) (*git.Repository, error) {
+	auth, err := git.NewAuth(repoCfg.Auth)
+	if err != nil {
+		return nil, fmt.Errorf("invalid credentials: %w", err)
+	}
+
+	refSpec := repoCfg.Ref
+	if refSpec == "" {
+		refSpec = repoCfg.Branch
+	}
+	ref, err := git.ParseRef(refSpec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ref: %w", err)
+	}
+
+	verifier, err := git.NewVerifier(repoCfg.SignatureVerification)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signature verification settings: %w", err)
+	}
+
+	repo := &git.Repository{
+		URL:       repoCfg.URL,
+		LocalPath: filepath.Join(gitCfg.CacheDir, repoCfg.Name),
+		Ref:       ref,
+		Auth:      auth,
+		Verifier:  verifier,
+		Depth:     gitCfg.Depth,
+		InMemory:  gitCfg.InMemory,
+	}
+	if ref.Type == git.RefBranch {
+		repo.Branch = ref.Value
+	}
+	return repo, nil
+}
+
+func ensureNamespace(ctx context.Context, c *k8s.Client, name string) error {
 
 	nsManifest := manifest.Manifest{
 		Kind: "Namespace",
@@ -103,10 +231,10 @@ func ensureNamespace(c *k8s.Client, name string) error {
 			},
 		},
 	}
-	return c.Apply(nsManifest, false)
+	return c.Apply(ctx, nsManifest, false)
 }
 
-func deleteNamespace(c *k8s.Client, name string) error {
+func deleteNamespace(ctx context.Context, c *k8s.Client, name string) error {
 	nsManifest := manifest.Manifest{
 		Kind: "Namespace",
 		Name: name,
@@ -118,5 +246,5 @@ func deleteNamespace(c *k8s.Client, name string) error {
 			},
 		},
 	}
-	return c.Delete(nsManifest)
+	return c.Delete(ctx, nsManifest)
 }
@@ -0,0 +1,215 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/MyoMyatMin/gitops-controller/internal/log"
+	"github.com/MyoMyatMin/gitops-controller/internal/sync"
+	"github.com/sirupsen/logrus"
+)
+
+const maxControlBodyBytes = 1 << 20
+
+type syncRequest struct {
+	// Revision is a commit SHA or any revision git can resolve. Syncing a
+	// revision without dry_run suspends auto-sync.
+	Revision string `json:"revision"`
+	Prune    *bool  `json:"prune"`
+	DryRun   bool   `json:"dry_run"`
+	// Wait holds the request open until the sync finishes and returns its result.
+	Wait bool `json:"wait"`
+}
+
+type controlResponse struct {
+	Repository string `json:"repository"`
+	Suspended  bool   `json:"suspended"`
+	Message    string `json:"message"`
+}
+
+// requireToken only lets requests carrying the configured bearer token through.
+// Without a configured token every request is refused.
+func (s *WebhookServer) requireToken(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.apiToken == "" {
+			http.Error(w, "API token not configured", http.StatusForbidden)
+			return
+		}
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.apiToken)) != 1 {
+			log.WithFields(logrus.Fields{"path": r.URL.Path, "source": requestSource(r)}).Warn("API request rejected: Invalid token.")
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// requireLeader refuses requests that change sync state on a follower, where
+// they would have no effect on the replica that syncs.
+func (s *WebhookServer) requireLeader(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.leadership.IsLeader() {
+			w.Header().Set("Retry-After", retryAfterSeconds)
+			http.Error(w, "Not the leader", http.StatusServiceUnavailable)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (s *WebhookServer) handleSyncRepository(w http.ResponseWriter, r *http.Request) {
+	engine := s.engine(r.PathValue("name"))
+	if engine == nil {
+		http.Error(w, "Repository not found", http.StatusNotFound)
+		return
+	}
+
+	var req syncRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxControlBodyBytes)).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "Error parsing JSON body", http.StatusBadRequest)
+		return
+	}
+	opts := sync.SyncOptions{Revision: req.Revision, Prune: req.Prune, DryRun: req.DryRun}
+
+	log.WithFields(logrus.Fields{
+		"repository": engine.Name(),
+		"revision":   req.Revision,
+		"dry_run":    req.DryRun,
+		"source":     requestSource(r),
+	}).Info("Manual sync requested.")
+
+	leaderCtx, leading := s.leadership.Context()
+	if !leading {
+		w.Header().Set("Retry-After", retryAfterSeconds)
+		http.Error(w, "Not the leader", http.StatusServiceUnavailable)
+		return
+	}
+
+	if !req.Wait {
+		// Report what the sync will leave behind rather than the state at the
+		// time of the request: syncing a revision suspends auto-sync.
+		pinned := opts.Revision != "" && !opts.DryRun
+		suspended := pinned || engine.Suspended()
+		message := "Accepted: Sync triggered."
+		if pinned {
+			message = "Accepted: Sync of " + opts.Revision + " triggered. Auto-sync will be suspended until resumed."
+		}
+		s.goSync(func() { s.manualSync(leaderCtx, engine, opts) })
+		writeJSON(w, http.StatusAccepted, controlResponse{
+			Repository: engine.Name(),
+			Suspended:  suspended,
+			Message:    message,
+		})
+		return
+	}
+
+	s.inflight.Add(1)
+	defer s.inflight.Done()
+
+	// The sync stops if the client goes away or leadership is lost.
+	ctx, cancel := context.WithCancel(leaderCtx)
+	defer cancel()
+	defer context.AfterFunc(r.Context(), cancel)()
+
+	result, err := engine.SyncWithOptions(ctx, sync.TriggerManual, opts)
+	if err != nil {
+		log.Errorf("Manual sync of %s failed: %v", engine.Name(), err)
+		http.Error(w, "Sync failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, newSyncResult(result))
+}
+
+// manualSync runs a sync requested without wait. It is bounded by ctx rather
+// than by the request.
+func (s *WebhookServer) manualSync(ctx context.Context, engine *sync.Engine, opts sync.SyncOptions) {
+	result, err := engine.SyncWithOptions(ctx, sync.TriggerManual, opts)
+	if err != nil {
+		log.Errorf("Manual sync of %s failed: %v", engine.Name(), err)
+		return
+	}
+	log.WithFields(logrus.Fields{
+		"repository": engine.Name(),
+		"commit":     result.CommitSHA,
+		"status":     result.Status,
+		"dry_run":    result.DryRun,
+	}).Info("Manual sync complete.")
+}
+
+func (s *WebhookServer) handleSuspendRepository(w http.ResponseWriter, r *http.Request) {
+	engine := s.engine(r.PathValue("name"))
+	if engine == nil {
+		http.Error(w, "Repository not found", http.StatusNotFound)
+		return
+	}
+	if err := engine.Suspend(r.Context()); err != nil {
+		log.Errorf("Error suspending %s: %v", engine.Name(), err)
+		http.Error(w, "Error suspending auto-sync: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, controlResponse{Repository: engine.Name(), Suspended: true, Message: "Auto-sync suspended."})
+}
+
+func (s *WebhookServer) handleResumeRepository(w http.ResponseWriter, r *http.Request) {
+	engine := s.engine(r.PathValue("name"))
+	if engine == nil {
+		http.Error(w, "Repository not found", http.StatusNotFound)
+		return
+	}
+	if err := engine.Resume(r.Context()); err != nil {
+		log.Errorf("Error resuming %s: %v", engine.Name(), err)
+		http.Error(w, "Error resuming auto-sync: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, controlResponse{Repository: engine.Name(), Suspended: false, Message: "Auto-sync resumed."})
+}
+
+// handleRollbackRepository re-applies the last commit that synced successfully
+// before the current one and suspends auto-sync. It responds once the sync
+// has finished.
+func (s *WebhookServer) handleRollbackRepository(w http.ResponseWriter, r *http.Request) {
+	engine := s.engine(r.PathValue("name"))
+	if engine == nil {
+		http.Error(w, "Repository not found", http.StatusNotFound)
+		return
+	}
+
+	log.WithFields(logrus.Fields{
+		"repository": engine.Name(),
+		"source":     requestSource(r),
+	}).Info("Rollback requested.")
+
+	leaderCtx, leading := s.leadership.Context()
+	if !leading {
+		w.Header().Set("Retry-After", retryAfterSeconds)
+		http.Error(w, "Not the leader", http.StatusServiceUnavailable)
+		return
+	}
+
+	s.inflight.Add(1)
+	defer s.inflight.Done()
+
+	// The rollback stops if the client goes away or leadership is lost.
+	ctx, cancel := context.WithCancel(leaderCtx)
+	defer cancel()
+	defer context.AfterFunc(r.Context(), cancel)()
+
+	result, err := engine.Rollback(ctx)
+	if errors.Is(err, sync.ErrNoRollbackTarget) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Errorf("Rollback of %s failed: %v", engine.Name(), err)
+		http.Error(w, "Rollback failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, newSyncResult(result))
+}
@@ -0,0 +1,90 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/MyoMyatMin/gitops-controller/internal/log"
+	"github.com/MyoMyatMin/gitops-controller/internal/sync"
+)
+
+type fieldChange struct {
+	Path    string      `json:"path"`
+	Live    interface{} `json:"live,omitempty"`
+	Desired interface{} `json:"desired,omitempty"`
+}
+
+type resourceDiff struct {
+	Kind      string        `json:"kind"`
+	Namespace string        `json:"namespace,omitempty"`
+	Name      string        `json:"name"`
+	Action    string        `json:"action"`
+	Fields    []fieldChange `json:"fields,omitempty"`
+	Diff      string        `json:"diff,omitempty"`
+}
+
+type diffResponse struct {
+	Repository      string         `json:"repository"`
+	Commit          string         `json:"commit"`
+	Ref             string         `json:"ref"`
+	HasChanges      bool           `json:"has_changes"`
+	Resources       []resourceDiff `json:"resources"`
+	PruneCandidates []string       `json:"prune_candidates"`
+	Prune           bool           `json:"prune"`
+	Errors          []string       `json:"errors"`
+}
+
+func newDiffResponse(d *sync.DiffResult) diffResponse {
+	response := diffResponse{
+		Repository:      d.Repository,
+		Commit:          d.CommitSHA,
+		Ref:             d.Ref,
+		HasChanges:      d.HasChanges(),
+		Resources:       make([]resourceDiff, 0, len(d.Resources)),
+		PruneCandidates: nonNil(d.PruneCandidates),
+		Prune:           d.Prune,
+		Errors:          []string{},
+	}
+	for _, r := range d.Resources {
+		rd := resourceDiff{Kind: r.Kind, Namespace: r.Namespace, Name: r.Name, Action: r.Action, Diff: r.Diff}
+		for _, f := range r.Fields {
+			rd.Fields = append(rd.Fields, fieldChange{Path: f.Path, Live: f.Live, Desired: f.Desired})
+		}
+		response.Resources = append(response.Resources, rd)
+	}
+	for _, err := range d.Errors {
+		response.Errors = append(response.Errors, err.Error())
+	}
+	return response
+}
+
+// handleRepositoryDiff previews a sync of the "revision" query parameter, or
+// of the tracked ref. With format=unified the diff is returned as plain text.
+func (s *WebhookServer) handleRepositoryDiff(w http.ResponseWriter, r *http.Request) {
+	engine := s.engine(r.PathValue("name"))
+	if engine == nil {
+		http.Error(w, "Repository not found", http.StatusNotFound)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "unified" {
+		http.Error(w, "Invalid format", http.StatusBadRequest)
+		return
+	}
+
+	result, err := engine.Diff(r.Context(), r.URL.Query().Get("revision"))
+	if err != nil {
+		log.Errorf("Diff of %s failed: %v", engine.Name(), err)
+		http.Error(w, "Diff failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if format == "unified" {
+		w.Header().Set("Content-Type", "text/x-diff; charset=utf-8")
+		if err := result.WriteUnified(w); err != nil {
+			log.Errorf("Error writing diff response: %v", err)
+		}
+		return
+	}
+	writeJSON(w, http.StatusOK, newDiffResponse(result))
+}
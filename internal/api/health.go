@@ -0,0 +1,85 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+type probeCheck struct {
+	Name    string `json:"name"`
+	OK      bool   `json:"ok"`
+	Message string `json:"message,omitempty"`
+}
+
+type probeResponse struct {
+	Status string       `json:"status"`
+	Checks []probeCheck `json:"checks"`
+}
+
+// add records the outcome of one check; a nil err passes.
+func (p *probeResponse) add(name string, err error) {
+	check := probeCheck{Name: name, OK: err == nil}
+	if err != nil {
+		check.Message = err.Error()
+	}
+	p.Checks = append(p.Checks, check)
+}
+
+// write responds 200 when every check passed and 503 otherwise.
+func (p *probeResponse) write(w http.ResponseWriter, okStatus, failStatus string) {
+	p.Status = okStatus
+	for _, check := range p.Checks {
+		if !check.OK {
+			p.Status = failStatus
+			writeJSON(w, http.StatusServiceUnavailable, p)
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, p)
+}
+
+// handleHealth is the liveness probe. It fails once a sync has been running
+// longer than the stuck sync threshold, which only a wedged sync can do.
+func (s *WebhookServer) handleHealth(w http.ResponseWriter, r *http.Request) {
+	response := probeResponse{Checks: []probeCheck{}}
+	for _, engine := range s.engines {
+		var err error
+		busySince := engine.Status().BusySince
+		if running := time.Since(busySince); s.stuckSyncThreshold > 0 && !busySince.IsZero() && running > s.stuckSyncThreshold {
+			err = fmt.Errorf("sync has been running for %s, longer than %s", running.Round(time.Second), s.stuckSyncThreshold)
+		}
+		response.add("sync/"+engine.Name(), err)
+	}
+	response.write(w, "ok", "failing")
+}
+
+// handleReady is the readiness probe. It passes once discovery against the
+// API server has succeeded and every repository has been cloned and, on the
+// leader, has had a first sync attempt.
+func (s *WebhookServer) handleReady(w http.ResponseWriter, r *http.Request) {
+	response := probeResponse{Checks: []probeCheck{}}
+
+	discoveryErr := s.k8sClient.CheckDiscovery()
+	if discoveryErr != nil {
+		discoveryErr = fmt.Errorf("discovery failed: %w", discoveryErr)
+	}
+	response.add("kubernetes", discoveryErr)
+
+	for _, engine := range s.engines {
+		status := engine.Status()
+		var err error
+		switch {
+		case !status.Cloned && status.Cloning:
+			err = fmt.Errorf("initial clone in progress")
+		case !status.Cloned && status.CloneError != nil:
+			err = fmt.Errorf("initial clone failed: %w", status.CloneError)
+		case !status.Cloned:
+			err = fmt.Errorf("initial clone has not started")
+		case !status.SyncAttempted && !status.Suspended && s.leadership.IsLeader():
+			err = fmt.Errorf("first sync has not been attempted")
+		}
+		response.add("repository/"+engine.Name(), err)
+	}
+	response.write(w, "ready", "not_ready")
+}
@@ -0,0 +1,109 @@
+package api
+
+import (
+	"net"
+	"net/http"
+	gosync "sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Reasons a delivery is rejected, used as the metric label.
+const (
+	rejectRateLimited      = "rate_limited"
+	rejectMissingSignature = "missing_signature"
+	rejectInvalidSignature = "invalid_signature"
+	rejectReplay           = "replay"
+)
+
+// limiterIdleTimeout is how long a source's rate limiter is kept after its last request.
+const limiterIdleTimeout = 10 * time.Minute
+
+// deliveryCache remembers delivery IDs for a window so redelivered or replayed
+// requests are only acted on once.
+type deliveryCache struct {
+	mu     gosync.Mutex
+	window time.Duration
+	seen   map[string]time.Time
+}
+
+func newDeliveryCache(window time.Duration) *deliveryCache {
+	return &deliveryCache{window: window, seen: make(map[string]time.Time)}
+}
+
+// firstSeen records id and reports whether it was not already seen within the window.
+func (c *deliveryCache) firstSeen(id string, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for seenID, at := range c.seen {
+		if now.Sub(at) > c.window {
+			delete(c.seen, seenID)
+		}
+	}
+
+	if _, ok := c.seen[id]; ok {
+		return false
+	}
+	c.seen[id] = now
+	return true
+}
+
+// forget drops id so that a redelivery is acted on, for deliveries that could
+// not be handled.
+func (c *deliveryCache) forget(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.seen, id)
+}
+
+type sourceLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// rateLimiter applies a token bucket per source address.
+type rateLimiter struct {
+	mu      gosync.Mutex
+	limit   rate.Limit
+	burst   int
+	sources map[string]*sourceLimiter
+}
+
+func newRateLimiter(limit float64, burst int) *rateLimiter {
+	return &rateLimiter{limit: rate.Limit(limit), burst: burst, sources: make(map[string]*sourceLimiter)}
+}
+
+func (l *rateLimiter) allow(source string, now time.Time) bool {
+	if l.limit <= 0 {
+		return true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for s, sl := range l.sources {
+		if now.Sub(sl.lastSeen) > limiterIdleTimeout {
+			delete(l.sources, s)
+		}
+	}
+
+	sl, ok := l.sources[source]
+	if !ok {
+		sl = &sourceLimiter{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.sources[source] = sl
+	}
+	sl.lastSeen = now
+	return sl.limiter.AllowN(now, 1)
+}
+
+// requestSource identifies the sender by its remote IP. Forwarding headers are
+// ignored since they are set by the client.
+func requestSource(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
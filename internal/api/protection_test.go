@@ -0,0 +1,84 @@
+package api
+
+import (
+	"testing"
+	"time"
+)
+
+const testReplayWindow = 10 * time.Minute
+
+func TestDeliveryCache(t *testing.T) {
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		id    string
+		after time.Duration
+		want  bool
+	}{
+		{"first delivery", "a", 0, true},
+		{"replay inside the window", "a", time.Minute, false},
+		{"other delivery", "b", time.Minute, true},
+		{"replay at the end of the window", "a", testReplayWindow, false},
+		{"replay after the window", "a", testReplayWindow + time.Second, true},
+	}
+
+	c := newDeliveryCache(testReplayWindow)
+	for _, tt := range tests {
+		if got := c.firstSeen(tt.id, start.Add(tt.after)); got != tt.want {
+			t.Errorf("%s: firstSeen(%q) = %v, want %v", tt.name, tt.id, got, tt.want)
+		}
+	}
+}
+
+func TestDeliveryCacheForget(t *testing.T) {
+	now := time.Now()
+	c := newDeliveryCache(testReplayWindow)
+	c.firstSeen("a", now)
+	c.forget("a")
+	if !c.firstSeen("a", now) {
+		t.Error("firstSeen() = false after forget, want true")
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	l := newRateLimiter(1, 3)
+
+	for i := 0; i < 3; i++ {
+		if !l.allow("10.0.0.1", start) {
+			t.Fatalf("request %d within the burst was refused", i+1)
+		}
+	}
+	if l.allow("10.0.0.1", start) {
+		t.Error("request beyond the burst was allowed")
+	}
+	if !l.allow("10.0.0.2", start) {
+		t.Error("request from another source was refused")
+	}
+
+	if l.allow("10.0.0.1", start.Add(500*time.Millisecond)) {
+		t.Error("request before a token was refilled was allowed")
+	}
+	if !l.allow("10.0.0.1", start.Add(time.Second)) {
+		t.Error("request after a token was refilled was refused")
+	}
+	if l.allow("10.0.0.1", start.Add(time.Second)) {
+		t.Error("second request after one token was refilled was allowed")
+	}
+	for i := 0; i < 3; i++ {
+		if !l.allow("10.0.0.1", start.Add(10*time.Second)) {
+			t.Fatalf("request %d after the bucket refilled was refused", i+1)
+		}
+	}
+}
+
+func TestRateLimiterDisabled(t *testing.T) {
+	l := newRateLimiter(0, 0)
+	now := time.Now()
+	for i := 0; i < 100; i++ {
+		if !l.allow("10.0.0.1", now) {
+			t.Fatalf("request %d was refused with rate limiting disabled", i+1)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+var (
+	errMissingSignature = errors.New("missing signature")
+	errInvalidSignature = errors.New("invalid signature")
+)
+
+// pushEvent is a push normalized across providers. Refs holds the full names
+// of the branches and tags that were created or updated; deletions are dropped.
+type pushEvent struct {
+	Provider   string
+	Repository string
+	CloneURLs  []string
+	Refs       []string
+}
+
+type webhookProvider struct {
+	name string
+	// eventHeader carries the event type; pushEvents are the values treated as pushes.
+	eventHeader string
+	pushEvents  []string
+	// deliveryHeader carries an ID that is unique per delivery, used for replay protection.
+	deliveryHeader string
+	verify         func(r *http.Request, body []byte, secret string) error
+	parse          func(body []byte) (*pushEvent, error)
+}
+
+var (
+	githubProvider = &webhookProvider{
+		name:           "github",
+		eventHeader:    "X-GitHub-Event",
+		pushEvents:     []string{"push"},
+		deliveryHeader: "X-GitHub-Delivery",
+		verify: func(r *http.Request, body []byte, secret string) error {
+			return verifyPrefixedHMAC(body, secret, r.Header.Get("X-Hub-Signature-256"))
+		},
+		parse: parseGitHubPush,
+	}
+
+	gitlabProvider = &webhookProvider{
+		name:           "gitlab",
+		eventHeader:    "X-Gitlab-Event",
+		pushEvents:     []string{"Push Hook", "Tag Push Hook"},
+		deliveryHeader: "X-Gitlab-Event-UUID",
+		verify:         verifyGitLabToken,
+		parse:          parseGitLabPush,
+	}
+
+	giteaProvider = &webhookProvider{
+		name:           "gitea",
+		eventHeader:    "X-Gitea-Event",
+		pushEvents:     []string{"push"},
+		deliveryHeader: "X-Gitea-Delivery",
+		verify: func(r *http.Request, body []byte, secret string) error {
+			return verifyHMAC(body, secret, r.Header.Get("X-Gitea-Signature"))
+		},
+		parse: parseGitHubPush,
+	}
+
+	bitbucketProvider = &webhookProvider{
+		name:           "bitbucket",
+		eventHeader:    "X-Event-Key",
+		pushEvents:     []string{"repo:push"},
+		deliveryHeader: "X-Request-UUID",
+		verify: func(r *http.Request, body []byte, secret string) error {
+			return verifyPrefixedHMAC(body, secret, r.Header.Get("X-Hub-Signature"))
+		},
+		parse: parseBitbucketPush,
+	}
+
+	// providers is in detection order: Gitea also sends X-GitHub-Event, so it
+	// has to be recognised before GitHub.
+	providers = []*webhookProvider{giteaProvider, gitlabProvider, bitbucketProvider, githubProvider}
+)
+
+// detectProvider identifies the sender from its event header, defaulting to
+// GitHub for deliveries that carry none.
+func detectProvider(r *http.Request) *webhookProvider {
+	for _, p := range providers {
+		if r.Header.Get(p.eventHeader) != "" {
+			return p
+		}
+	}
+	return githubProvider
+}
+
+func (p *webhookProvider) isPush(event string) bool {
+	if event == "" {
+		return true
+	}
+	for _, e := range p.pushEvents {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyHMAC checks a hex-encoded HMAC-SHA256 of body.
+func verifyHMAC(body []byte, secret, signature string) error {
+	if signature == "" {
+		return errMissingSignature
+	}
+	expectedMAC, err := hex.DecodeString(signature)
+	if err != nil {
+		return errInvalidSignature
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	if !hmac.Equal(mac.Sum(nil), expectedMAC) {
+		return errInvalidSignature
+	}
+	return nil
+}
+
+// verifyPrefixedHMAC checks a "sha256=<hex>" signature as sent by GitHub and Bitbucket.
+func verifyPrefixedHMAC(body []byte, secret, signature string) error {
+	if signature == "" {
+		return errMissingSignature
+	}
+	hexMAC, ok := strings.CutPrefix(signature, "sha256=")
+	if !ok {
+		return errInvalidSignature
+	}
+	return verifyHMAC(body, secret, hexMAC)
+}
+
+// verifyGitLabToken checks the shared secret GitLab sends verbatim.
+func verifyGitLabToken(r *http.Request, body []byte, secret string) error {
+	token := r.Header.Get("X-Gitlab-Token")
+	if token == "" {
+		return errMissingSignature
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(secret)) != 1 {
+		return errInvalidSignature
+	}
+	return nil
+}
+
+// githubPushPayload is the push payload of GitHub and of Gitea, which mirrors it.
+type githubPushPayload struct {
+	Ref        string `json:"ref"`
+	After      string `json:"after"`
+	Deleted    bool   `json:"deleted"`
+	Repository struct {
+		FullName string `json:"full_name"`
+		HTMLURL  string `json:"html_url"`
+		CloneURL string `json:"clone_url"`
+		SSHURL   string `json:"ssh_url"`
+		GitURL   string `json:"git_url"`
+	} `json:"repository"`
+}
+
+func parseGitHubPush(body []byte) (*pushEvent, error) {
+	var payload githubPushPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, err
+	}
+
+	repo := payload.Repository
+	event := &pushEvent{
+		Repository: repo.FullName,
+		CloneURLs:  []string{repo.CloneURL, repo.SSHURL, repo.GitURL, repo.HTMLURL},
+	}
+	if !payload.Deleted && !isZeroSHA(payload.After) {
+		event.Refs = []string{payload.Ref}
+	}
+	return event, nil
+}
+
+type gitlabPushPayload struct {
+	Ref     string `json:"ref"`
+	After   string `json:"after"`
+	Project struct {
+		PathWithNamespace string `json:"path_with_namespace"`
+		WebURL            string `json:"web_url"`
+		GitHTTPURL        string `json:"git_http_url"`
+		GitSSHURL         string `json:"git_ssh_url"`
+	} `json:"project"`
+}
+
+func parseGitLabPush(body []byte) (*pushEvent, error) {
+	var payload gitlabPushPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, err
+	}
+
+	project := payload.Project
+	event := &pushEvent{
+		Repository: project.PathWithNamespace,
+		CloneURLs:  []string{project.GitHTTPURL, project.GitSSHURL, project.WebURL},
+	}
+	if !isZeroSHA(payload.After) {
+		event.Refs = []string{payload.Ref}
+	}
+	return event, nil
+}
+
+type bitbucketPushPayload struct {
+	Push struct {
+		Changes []struct {
+			New *struct {
+				Type string `json:"type"`
+				Name string `json:"name"`
+			} `json:"new"`
+		} `json:"changes"`
+	} `json:"push"`
+	Repository struct {
+		FullName string `json:"full_name"`
+		Links    struct {
+			HTML struct {
+				Href string `json:"href"`
+			} `json:"html"`
+		} `json:"links"`
+	} `json:"repository"`
+}
+
+func parseBitbucketPush(body []byte) (*pushEvent, error) {
+	var payload bitbucketPushPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, err
+	}
+
+	event := &pushEvent{
+		Repository: payload.Repository.FullName,
+		CloneURLs:  []string{payload.Repository.Links.HTML.Href},
+	}
+	for _, change := range payload.Push.Changes {
+		if change.New == nil {
+			continue
+		}
+		switch change.New.Type {
+		case "branch":
+			event.Refs = append(event.Refs, "refs/heads/"+change.New.Name)
+		case "tag", "annotated_tag":
+			event.Refs = append(event.Refs, "refs/tags/"+change.New.Name)
+		}
+	}
+	return event, nil
+}
+
+func isZeroSHA(sha string) bool {
+	return sha != "" && strings.Trim(sha, "0") == ""
+}
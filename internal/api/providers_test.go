@@ -0,0 +1,103 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testSecret = "s3cret"
+
+func hmacHex(body []byte, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestProviderSignatures(t *testing.T) {
+	body := []byte(`{"ref":"refs/heads/main"}`)
+	valid := hmacHex(body, testSecret)
+	wrong := hmacHex(body, "other")
+
+	tests := []struct {
+		name     string
+		provider *webhookProvider
+		header   string
+		value    string
+		wantErr  error
+	}{
+		{"github valid", githubProvider, "X-Hub-Signature-256", "sha256=" + valid, nil},
+		{"github missing", githubProvider, "", "", errMissingSignature},
+		{"github wrong secret", githubProvider, "X-Hub-Signature-256", "sha256=" + wrong, errInvalidSignature},
+		{"github without prefix", githubProvider, "X-Hub-Signature-256", valid, errInvalidSignature},
+		{"github not hex", githubProvider, "X-Hub-Signature-256", "sha256=zz", errInvalidSignature},
+		{"gitea valid", giteaProvider, "X-Gitea-Signature", valid, nil},
+		{"gitea missing", giteaProvider, "", "", errMissingSignature},
+		{"gitea wrong secret", giteaProvider, "X-Gitea-Signature", wrong, errInvalidSignature},
+		{"bitbucket valid", bitbucketProvider, "X-Hub-Signature", "sha256=" + valid, nil},
+		{"bitbucket missing", bitbucketProvider, "", "", errMissingSignature},
+		{"bitbucket wrong secret", bitbucketProvider, "X-Hub-Signature", "sha256=" + wrong, errInvalidSignature},
+		{"gitlab valid", gitlabProvider, "X-Gitlab-Token", testSecret, nil},
+		{"gitlab missing", gitlabProvider, "", "", errMissingSignature},
+		{"gitlab wrong token", gitlabProvider, "X-Gitlab-Token", "other", errInvalidSignature},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(string(body)))
+			if tt.header != "" {
+				r.Header.Set(tt.header, tt.value)
+			}
+			err := tt.provider.verify(r, body, testSecret)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("verify() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestWebhookInsecureSwitch(t *testing.T) {
+	body := `{"zen":"hello"}`
+
+	tests := []struct {
+		name       string
+		secret     string
+		insecure   bool
+		signature  string
+		wantStatus int
+	}{
+		{"no secret", "", false, "", http.StatusUnauthorized},
+		{"no secret in insecure mode", "", true, "", http.StatusOK},
+		{"secret ignores insecure mode", testSecret, true, "", http.StatusUnauthorized},
+		{"secret with valid signature", testSecret, false, "sha256=" + hmacHex([]byte(body), testSecret), http.StatusOK},
+		{"secret with invalid signature", testSecret, false, "sha256=" + hmacHex([]byte(body), "other"), http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &WebhookServer{
+				secret:     tt.secret,
+				insecure:   tt.insecure,
+				deliveries: newDeliveryCache(testReplayWindow),
+				limiter:    newRateLimiter(0, 0),
+			}
+			r := httptest.NewRequest(http.MethodPost, "/webhook/github", strings.NewReader(body))
+			// A ping is answered without reaching dispatch.
+			r.Header.Set("X-GitHub-Event", "ping")
+			if tt.signature != "" {
+				r.Header.Set("X-Hub-Signature-256", tt.signature)
+			}
+			w := httptest.NewRecorder()
+
+			s.webhookHandler(githubProvider)(w, r)
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,243 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/MyoMyatMin/gitops-controller/internal/log"
+	"github.com/MyoMyatMin/gitops-controller/internal/sync"
+)
+
+const (
+	defaultHistoryLimit = 20
+	maxHistoryLimit     = 100
+
+	commitsTimeout = 10 * time.Second
+)
+
+type repositoryStatus struct {
+	Name          string      `json:"name"`
+	URL           string      `json:"url"`
+	Ref           string      `json:"ref"`
+	Namespace     string      `json:"namespace"`
+	Path          string      `json:"path"`
+	Suspended     bool        `json:"suspended"`
+	LastSyncedSHA string      `json:"last_synced_sha,omitempty"`
+	LastSyncTime  *time.Time  `json:"last_sync_time,omitempty"`
+	LastResult    *syncResult `json:"last_result,omitempty"`
+	Drift         driftStatus `json:"drift"`
+	Cloned        bool        `json:"cloned"`
+	CloneError    string      `json:"clone_error,omitempty"`
+	SyncAttempted bool        `json:"sync_attempted"`
+	BusySince     *time.Time  `json:"busy_since,omitempty"`
+}
+
+type driftStatus struct {
+	Detected bool     `json:"detected"`
+	Reasons  []string `json:"reasons,omitempty"`
+}
+
+type resourceHealth struct {
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+type syncResult struct {
+	Commit     string                    `json:"commit,omitempty"`
+	Ref        string                    `json:"ref,omitempty"`
+	Trigger    string                    `json:"trigger"`
+	DryRun     bool                      `json:"dry_run"`
+	Status     string                    `json:"status"`
+	StartedAt  time.Time                 `json:"started_at"`
+	FinishedAt time.Time                 `json:"finished_at"`
+	Skipped    bool                      `json:"skipped"`
+	Created    []string                  `json:"created"`
+	Updated    []string                  `json:"updated"`
+	Deleted    []string                  `json:"deleted"`
+	Orphaned   []string                  `json:"orphaned"`
+	Health     map[string]resourceHealth `json:"health,omitempty"`
+	Drift      driftStatus               `json:"drift"`
+	Errors     []string                  `json:"errors"`
+}
+
+type historyPage struct {
+	Repository string       `json:"repository"`
+	Total      int          `json:"total"`
+	Offset     int          `json:"offset"`
+	Limit      int          `json:"limit"`
+	Items      []syncResult `json:"items"`
+}
+
+// syncedCommit is one entry of the history saved in a repository's inventory,
+// which survives restarts and is what rollbacks choose from.
+type syncedCommit struct {
+	Commit string    `json:"commit"`
+	Ref    string    `json:"ref"`
+	Status string    `json:"status"`
+	Time   time.Time `json:"time"`
+}
+
+type commitsResponse struct {
+	Repository string         `json:"repository"`
+	Items      []syncedCommit `json:"items"`
+}
+
+func newRepositoryStatus(status sync.EngineStatus) repositoryStatus {
+	rs := repositoryStatus{
+		Name:          status.Name,
+		URL:           status.URL,
+		Ref:           status.Ref,
+		Namespace:     status.Namespace,
+		Path:          status.Path,
+		Suspended:     status.Suspended,
+		LastSyncedSHA: status.LastSyncedSHA,
+		Drift:         driftStatus{Detected: status.Drift, Reasons: status.DriftReasons},
+		Cloned:        status.Cloned,
+		SyncAttempted: status.SyncAttempted,
+	}
+	if status.CloneError != nil {
+		rs.CloneError = status.CloneError.Error()
+	}
+	if !status.LastSyncTime.IsZero() {
+		rs.LastSyncTime = &status.LastSyncTime
+	}
+	if !status.BusySince.IsZero() {
+		rs.BusySince = &status.BusySince
+	}
+	if status.LastResult != nil {
+		result := newSyncResult(status.LastResult)
+		rs.LastResult = &result
+	}
+	return rs
+}
+
+func newSyncResult(r *sync.SyncResult) syncResult {
+	result := syncResult{
+		Commit:     r.CommitSHA,
+		Ref:        r.Ref,
+		Trigger:    string(r.Trigger),
+		DryRun:     r.DryRun,
+		Status:     r.Status,
+		StartedAt:  r.StartedAt,
+		FinishedAt: r.FinishedAt,
+		Skipped:    r.Skipped,
+		Created:    nonNil(r.Created),
+		Updated:    nonNil(r.Updated),
+		Deleted:    nonNil(r.Deleted),
+		Orphaned:   nonNil(r.Orphaned),
+		Drift:      driftStatus{Detected: r.Drift, Reasons: r.DriftReasons},
+		Errors:     []string{},
+	}
+	if len(r.Health) > 0 {
+		result.Health = make(map[string]resourceHealth, len(r.Health))
+		for key, h := range r.Health {
+			result.Health[key] = resourceHealth{Status: string(h.Status), Message: h.Message}
+		}
+	}
+	for _, err := range r.Errors {
+		result.Errors = append(result.Errors, err.Error())
+	}
+	return result
+}
+
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
+
+func (s *WebhookServer) engine(name string) *sync.Engine {
+	for _, engine := range s.engines {
+		if engine.Name() == name {
+			return engine
+		}
+	}
+	return nil
+}
+
+func (s *WebhookServer) handleListRepositories(w http.ResponseWriter, r *http.Request) {
+	repositories := make([]repositoryStatus, 0, len(s.engines))
+	for _, engine := range s.engines {
+		repositories = append(repositories, newRepositoryStatus(engine.Status()))
+	}
+	writeJSON(w, http.StatusOK, repositories)
+}
+
+func (s *WebhookServer) handleGetRepository(w http.ResponseWriter, r *http.Request) {
+	engine := s.engine(r.PathValue("name"))
+	if engine == nil {
+		http.Error(w, "Repository not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, newRepositoryStatus(engine.Status()))
+}
+
+func (s *WebhookServer) handleRepositoryHistory(w http.ResponseWriter, r *http.Request) {
+	engine := s.engine(r.PathValue("name"))
+	if engine == nil {
+		http.Error(w, "Repository not found", http.StatusNotFound)
+		return
+	}
+
+	offset, err := queryInt(r, "offset", 0)
+	if err != nil || offset < 0 {
+		http.Error(w, "Invalid offset", http.StatusBadRequest)
+		return
+	}
+	limit, err := queryInt(r, "limit", defaultHistoryLimit)
+	if err != nil || limit < 1 {
+		http.Error(w, "Invalid limit", http.StatusBadRequest)
+		return
+	}
+	limit = min(limit, maxHistoryLimit)
+
+	results, total := engine.RecentSyncs(offset, limit)
+	page := historyPage{
+		Repository: engine.Name(),
+		Total:      total,
+		Offset:     offset,
+		Limit:      limit,
+		Items:      make([]syncResult, 0, len(results)),
+	}
+	for _, result := range results {
+		page.Items = append(page.Items, newSyncResult(result))
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+// handleRepositoryCommits lists the commits recorded in the inventory, newest
+// first. Unlike /history it is shared by all replicas and kept across restarts.
+func (s *WebhookServer) handleRepositoryCommits(w http.ResponseWriter, r *http.Request) {
+	engine := s.engine(r.PathValue("name"))
+	if engine == nil {
+		http.Error(w, "Repository not found", http.StatusNotFound)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), commitsTimeout)
+	defer cancel()
+	history, err := engine.History(ctx)
+	if err != nil {
+		log.Errorf("Error reading history of %s: %v", engine.Name(), err)
+		http.Error(w, "Error reading history: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	response := commitsResponse{Repository: engine.Name(), Items: make([]syncedCommit, 0, len(history))}
+	for i := len(history) - 1; i >= 0; i-- {
+		record := history[i]
+		response.Items = append(response.Items, syncedCommit{Commit: record.Commit, Ref: record.Ref, Status: record.Status, Time: record.Time})
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+func queryInt(r *http.Request, key string, fallback int) (int, error) {
+	value := r.URL.Query().Get(key)
+	if value == "" {
+		return fallback, nil
+	}
+	return strconv.Atoi(value)
+}
@@ -1,129 +1,339 @@
 package api
 
 import (
-	"crypto/hmac"
-	"crypto/sha256"
-	"encoding/hex"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	_ "net/http/pprof"
 	"strings"
+	gosync "sync"
+	"time"
 
+	"github.com/MyoMyatMin/gitops-controller/internal/config"
+	"github.com/MyoMyatMin/gitops-controller/internal/k8s"
+	"github.com/MyoMyatMin/gitops-controller/internal/leader"
 	"github.com/MyoMyatMin/gitops-controller/internal/log"
+	"github.com/MyoMyatMin/gitops-controller/internal/metrics"
 	"github.com/MyoMyatMin/gitops-controller/internal/sync"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
 )
 
 type WebhookServer struct {
-	engines []*sync.Engine
-	secret  string
+	engines    []*sync.Engine
+	k8sClient  *k8s.Client
+	secret     string
+	insecure   bool
+	deliveries *deliveryCache
+	limiter    *rateLimiter
+	apiToken   string
+
+	stuckSyncThreshold time.Duration
+
+	// leadership decides whether this replica may sync. Syncs triggered
+	// through the server run until it is lost.
+	leadership *leader.Leadership
+	// inflight counts syncs started by the server that have not finished.
+	inflight gosync.WaitGroup
+}
+
+const (
+	shutdownTimeout = 10 * time.Second
+	maxPayloadBytes = 25 << 20
+
+	// retryAfterSeconds is suggested to clients of a replica that is not the leader.
+	retryAfterSeconds = "5"
+)
+
+type webhookResponse struct {
+	Provider  string   `json:"provider"`
+	Refs      []string `json:"refs"`
+	Triggered []string `json:"triggered"`
+	Suspended []string `json:"suspended,omitempty"`
+	Message   string   `json:"message"`
 }
 
-func NewWebhookServer(engines []*sync.Engine, secret string) *WebhookServer {
+func NewWebhookServer(engines []*sync.Engine, k8sClient *k8s.Client, leadership *leader.Leadership, appCfg *config.Config) *WebhookServer {
+	cfg, apiCfg := appCfg.Webhook, appCfg.API
+	if cfg.Secret == "" && cfg.Insecure {
+		log.Warn("Webhook secret is not set and insecure mode is enabled. Deliveries will not be authenticated.")
+	}
+	if apiCfg.Token == "" {
+		log.Info("API token is not set. Sync, suspend, resume, rollback and diff endpoints are disabled.")
+	}
+
 	return &WebhookServer{
-		engines: engines,
-		secret:  secret,
+		engines:    engines,
+		k8sClient:  k8sClient,
+		secret:     cfg.Secret,
+		insecure:   cfg.Insecure,
+		deliveries: newDeliveryCache(cfg.ReplayWindow),
+		limiter:    newRateLimiter(cfg.RateLimit, cfg.RateBurst),
+		apiToken:   apiCfg.Token,
+
+		stuckSyncThreshold: appCfg.Health.StuckSyncThreshold,
+		leadership:         leadership,
 	}
 }
 
-func (s *WebhookServer) Start(port int) error {
+// Start serves until ctx is cancelled, then shuts the server down.
+func (s *WebhookServer) Start(ctx context.Context, port int) error {
 	log.Infof("Starting webhook server on port %d...", port)
+
 	mux := http.NewServeMux()
-	mux.HandleFunc("/webhook", s.handleGitHubWebhook)
+	mux.HandleFunc("/webhook", s.webhookHandler(nil))
+	for _, p := range providers {
+		mux.HandleFunc("/webhook/"+p.name, s.webhookHandler(p))
+	}
+
+	mux.HandleFunc("GET /api/v1/repositories", s.handleListRepositories)
+	mux.HandleFunc("GET /api/v1/repositories/{name}", s.handleGetRepository)
+	mux.HandleFunc("GET /api/v1/repositories/{name}/history", s.handleRepositoryHistory)
+	mux.HandleFunc("GET /api/v1/repositories/{name}/commits", s.handleRepositoryCommits)
+	mux.HandleFunc("GET /api/v1/repositories/{name}/diff", s.requireToken(s.handleRepositoryDiff))
+	mux.HandleFunc("POST /api/v1/repositories/{name}/sync", s.requireToken(s.requireLeader(s.handleSyncRepository)))
+	mux.HandleFunc("POST /api/v1/repositories/{name}/suspend", s.requireToken(s.requireLeader(s.handleSuspendRepository)))
+	mux.HandleFunc("POST /api/v1/repositories/{name}/resume", s.requireToken(s.requireLeader(s.handleResumeRepository)))
+	mux.HandleFunc("POST /api/v1/repositories/{name}/rollback", s.requireToken(s.requireLeader(s.handleRollbackRepository)))
 
 	mux.Handle("/metrics", promhttp.Handler())
 	mux.HandleFunc("/health", s.handleHealth)
+	mux.HandleFunc("/ready", s.handleReady)
 
-	mux.HandleFunc("/ready", s.handleHealth)
+	server := &http.Server{Addr: fmt.Sprintf(":%d", port), Handler: mux}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Errorf("Error shutting down webhook server: %v", err)
+		}
+	}()
 
-	return http.ListenAndServe(fmt.Sprintf(":%d", port), mux)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
 }
 
-func (s *WebhookServer) handleHealth(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("OK"))
+// Wait blocks until every sync started by the server has finished.
+func (s *WebhookServer) Wait() {
+	s.inflight.Wait()
 }
-func (s *WebhookServer) handleGitHubWebhook(w http.ResponseWriter, r *http.Request) {
 
-	if r.Method != http.MethodPost {
-		log.Warnf("Invalid webhook method: %s", r.Method)
-		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
-		return
+// goSync runs fn in the background, tracked by Wait.
+func (s *WebhookServer) goSync(fn func()) {
+	s.inflight.Add(1)
+	go func() {
+		defer s.inflight.Done()
+		fn()
+	}()
+}
+
+// webhookHandler serves push webhooks from provider, or from whichever
+// provider the request headers identify when provider is nil.
+func (s *WebhookServer) webhookHandler(provider *webhookProvider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			log.Warnf("Invalid webhook method: %s", r.Method)
+			http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		p := provider
+		if p == nil {
+			p = detectProvider(r)
+		}
+		source := requestSource(r)
+
+		if !s.limiter.allow(source, time.Now()) {
+			s.reject(w, p, source, rejectRateLimited, http.StatusTooManyRequests, "Too many requests")
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxPayloadBytes))
+		if err != nil {
+			log.Errorf("Error reading webhook body: %v", err)
+			http.Error(w, "Error reading request body", http.StatusBadRequest)
+			return
+		}
+
+		if err := s.verifySignature(p, r, body); err != nil {
+			reason := rejectInvalidSignature
+			if errors.Is(err, errMissingSignature) {
+				reason = rejectMissingSignature
+			}
+			s.reject(w, p, source, reason, http.StatusUnauthorized, "Invalid signature")
+			return
+		}
+
+		response := webhookResponse{Provider: p.name, Refs: []string{}, Triggered: []string{}}
+
+		if eventType := r.Header.Get(p.eventHeader); !p.isPush(eventType) {
+			log.Infof("Webhook ignored: %s %q event is not a push.", p.name, eventType)
+			response.Message = fmt.Sprintf("Ignored: %q is not a push event.", eventType)
+			writeJSON(w, http.StatusOK, response)
+			return
+		}
+
+		event, err := p.parse(body)
+		if err != nil {
+			log.Errorf("Error parsing %s webhook JSON payload: %v", p.name, err)
+			http.Error(w, "Error parsing JSON payload", http.StatusBadRequest)
+			return
+		}
+		event.Provider = p.name
+
+		s.dispatch(w, r, p, event, response)
 	}
+}
 
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		log.Errorf("Error reading webhook body: %v", err)
-		http.Error(w, "Error reading request body", http.StatusInternalServerError)
-		return
+// dispatch triggers a sync of every engine tracking one of the pushed refs and
+// reports which were triggered. A follower cannot sync and instead records a
+// request in the inventory, which the leader picks up.
+func (s *WebhookServer) dispatch(w http.ResponseWriter, r *http.Request, p *webhookProvider, event *pushEvent, response webhookResponse) {
+	for _, ref := range event.Refs {
+		if strings.HasPrefix(ref, "refs/heads/") || strings.HasPrefix(ref, "refs/tags/") {
+			response.Refs = append(response.Refs, ref)
+		}
 	}
+	logFields := logrus.Fields{"provider": event.Provider, "refs": response.Refs, "repository": event.Repository}
 
-	signature := r.Header.Get("X-Hub-Signature-256")
-	if !s.isValidSignature(body, signature) {
-		log.Warn("Webhook failed: Invalid signature")
-		http.Error(w, "Invalid signature", http.StatusUnauthorized)
+	if len(response.Refs) == 0 {
+		log.WithFields(logFields).Info("Webhook ignored: Not a branch or tag push event.")
+		response.Message = "Ignored: Not a branch or tag push event."
+		writeJSON(w, http.StatusOK, response)
 		return
 	}
 
-	var payload struct {
-		Ref string `json:"ref"`
+	log.WithFields(logFields).Info("--- Valid push webhook received! ---")
+
+	leaderCtx, leading := s.leadership.Context()
+
+	// Deliveries are only recorded once they are handled, so that a
+	// redelivery after a failure is not ignored.
+	var delivery string
+	if id := r.Header.Get(p.deliveryHeader); id != "" {
+		delivery = p.name + "/" + id
+		if !s.deliveries.firstSeen(delivery, time.Now()) {
+			metrics.WebhookRejected.WithLabelValues(p.name, rejectReplay).Inc()
+			log.WithFields(logrus.Fields{"provider": p.name, "delivery": id, "source": requestSource(r)}).Warn("Webhook ignored: Duplicate delivery.")
+			response.Message = "Ignored: Duplicate delivery."
+			writeJSON(w, http.StatusOK, response)
+			return
+		}
 	}
-	if err := json.Unmarshal(body, &payload); err != nil {
-		log.Errorf("Error parsing webhook JSON payload: %v", err)
-		http.Error(w, "Error parsing JSON payload", http.StatusBadRequest)
-		return
+
+	var failed []string
+	for _, engine := range s.engines {
+		if !matchesAnyRef(engine, event.CloneURLs, response.Refs) {
+			continue
+		}
+		if engine.Suspended() {
+			log.Infof("Auto-sync suspended for %s, ignoring webhook.", engine.Name())
+			response.Suspended = append(response.Suspended, engine.Name())
+			continue
+		}
+		if !leading {
+			if err := engine.RequestSync(r.Context()); err != nil {
+				log.Errorf("Error requesting sync of %s from the leader: %v", engine.Name(), err)
+				failed = append(failed, engine.Name())
+				continue
+			}
+			response.Triggered = append(response.Triggered, engine.Name())
+			continue
+		}
+		response.Triggered = append(response.Triggered, engine.Name())
+		s.goSync(func() { s.sync(leaderCtx, engine) })
 	}
 
-	ref := payload.Ref
-	logFields := logrus.Fields{"ref": ref}
+	if len(failed) > 0 {
+		if delivery != "" {
+			s.deliveries.forget(delivery)
+		}
+		log.WithFields(logFields).Warnf("Webhook not handled: Could not request sync of %v from the leader.", failed)
+		response.Message = "Unavailable: Could not request a sync from the leader."
+		w.Header().Set("Retry-After", retryAfterSeconds)
+		writeJSON(w, http.StatusServiceUnavailable, response)
+		return
+	}
 
-	if !strings.HasPrefix(ref, "refs/heads/") {
-		log.WithFields(logFields).Info("Webhook ignored: Not a branch push event.")
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("Ignored: Not a branch push event."))
+	if len(response.Triggered) == 0 {
+		response.Message = "Ignored: No repository tracks this ref."
+		if len(response.Suspended) > 0 {
+			response.Message = "Ignored: Auto-sync is suspended for every matching repository."
+		}
+		log.WithFields(logFields).Info(response.Message)
+		writeJSON(w, http.StatusOK, response)
 		return
 	}
 
-	log.WithFields(logFields).Info("--- Valid GitHub webhook received! Triggering sync. ---")
+	log.WithFields(logFields).Infof("Triggered sync for %v", response.Triggered)
+	response.Message = "Accepted: Sync triggered."
+	if !leading {
+		response.Message = "Accepted: Sync requested from the leader."
+	}
+	writeJSON(w, http.StatusAccepted, response)
+}
 
-	go func() {
-		for _, engine := range s.engines {
-			_, err := engine.Sync()
-			if err != nil {
-				log.Errorf("Webhook-triggered sync failed: %v", err)
-			} else {
-				log.Info("Webhook-triggered sync successful.")
-				// You might want to log which repo synced, but Sync() logs usually cover it
-			}
+func matchesAnyRef(engine *sync.Engine, cloneURLs, refs []string) bool {
+	for _, ref := range refs {
+		if engine.MatchesPush(cloneURLs, ref) {
+			return true
 		}
-	}()
-
-	w.WriteHeader(http.StatusAccepted)
-	w.Write([]byte("Accepted: Sync triggered."))
+	}
+	return false
 }
 
-func (s *WebhookServer) isValidSignature(body []byte, signature string) bool {
-	if s.secret == "" {
-		log.Warn("Webhook secret is not set. Skipping validation.")
-		return true
+// sync runs a webhook-triggered sync. The engine queue coalesces it with any
+// sync already pending for the same repository.
+func (s *WebhookServer) sync(ctx context.Context, engine *sync.Engine) {
+	result, err := engine.Sync(ctx, sync.TriggerWebhook)
+	if errors.Is(err, sync.ErrSuspended) {
+		log.Infof("Auto-sync suspended for %s, ignoring webhook.", engine.Name())
+		return
 	}
-	if signature == "" {
-		log.Info("Webhook received with no signature. Allowing for test.")
-		return true
+	if err != nil {
+		log.Errorf("Webhook-triggered sync of %s failed: %v", engine.Name(), err)
+		return
 	}
-	if !strings.HasPrefix(signature, "sha256=") {
-		return false
+	log.WithFields(logrus.Fields{
+		"repository": engine.Name(),
+		"commit":     result.CommitSHA,
+		"status":     result.Status,
+		"trigger":    result.Trigger,
+	}).Info("Webhook-triggered sync complete.")
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Errorf("Error writing JSON response: %v", err)
 	}
-	expectedMAC, err := hex.DecodeString(signature[7:])
-	if err != nil {
-		return false
+}
+
+// verifySignature authenticates a delivery. Without a secret, deliveries are
+// only accepted in insecure mode.
+func (s *WebhookServer) verifySignature(p *webhookProvider, r *http.Request, body []byte) error {
+	if s.secret == "" {
+		if s.insecure {
+			return nil
+		}
+		return errors.New("no webhook secret configured")
 	}
-	mac := hmac.New(sha256.New, []byte(s.secret))
-	mac.Write(body)
-	actualMAC := mac.Sum(nil)
+	return p.verify(r, body, s.secret)
+}
 
-	return hmac.Equal(actualMAC, expectedMAC)
+func (s *WebhookServer) reject(w http.ResponseWriter, p *webhookProvider, source, reason string, status int, message string) {
+	metrics.WebhookRejected.WithLabelValues(p.name, reason).Inc()
+	log.WithFields(logrus.Fields{
+		"provider": p.name,
+		"source":   source,
+		"reason":   reason,
+	}).Warn("Webhook rejected")
+	http.Error(w, message, status)
 }
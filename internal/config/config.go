@@ -7,31 +7,115 @@ import (
 
 	"github.com/MyoMyatMin/gitops-controller/internal/log"
 	"github.com/spf13/viper"
+	"k8s.io/apimachinery/pkg/util/validation"
 )
 
+// InventoryNamePrefix prefixes the name of each repository's inventory
+// ConfigMap, so repository names must also be valid in that name.
+const InventoryNamePrefix = "gitops-inventory-"
+
 type Config struct {
-	Kubernetes   K8sConfig          `mapstructure:"kubernetes"`
-	Webhook      WebhookConfig      `mapstructure:"webhook"`
-	Repositories []RepositoryConfig `mapstructure:"repositories"`
+	Kubernetes     K8sConfig            `mapstructure:"kubernetes"`
+	Webhook        WebhookConfig        `mapstructure:"webhook"`
+	API            APIConfig            `mapstructure:"api"`
+	Health         HealthConfig         `mapstructure:"health"`
+	LeaderElection LeaderElectionConfig `mapstructure:"leader_election"`
+	Git            GitConfig            `mapstructure:"git"`
+	Repositories   []RepositoryConfig   `mapstructure:"repositories"`
 }
 type RepositoryConfig struct {
-	Name      string        `mapstructure:"name"`
-	URL       string        `mapstructure:"url"`
-	Branch    string        `mapstructure:"branch"`
-	Path      string        `mapstructure:"path"`
-	Namespace string        `mapstructure:"namespace"`
-	Interval  time.Duration `mapstructure:"interval"`
-	Prune     bool          `mapstructure:"prune"`
+	Name            string        `mapstructure:"name"`
+	URL             string        `mapstructure:"url"`
+	Branch          string        `mapstructure:"branch"`
+	Ref             string        `mapstructure:"ref"`
+	Path            string        `mapstructure:"path"`
+	DependencyPaths []string      `mapstructure:"dependency_paths"`
+	Namespace       string        `mapstructure:"namespace"`
+	Interval        time.Duration `mapstructure:"interval"`
+	Prune           bool          `mapstructure:"prune"`
+
+	SyncTimeout   time.Duration `mapstructure:"sync_timeout"`
+	HealthTimeout time.Duration `mapstructure:"health_timeout"`
+	WaitForHealth bool          `mapstructure:"wait_for_health"`
+
+	Helm                  HelmConfig      `mapstructure:"helm"`
+	Auth                  GitAuthConfig   `mapstructure:"auth"`
+	SignatureVerification SignatureConfig `mapstructure:"signature_verification"`
+}
+
+type SignatureConfig struct {
+	Enabled     bool     `mapstructure:"enabled"`
+	GPGKeyFiles []string `mapstructure:"gpg_key_files"`
+	SSHKeyFiles []string `mapstructure:"ssh_key_files"`
+}
+
+type GitAuthConfig struct {
+	SSHKeyPath          string `mapstructure:"ssh_key_path"`
+	SSHKeyEnv           string `mapstructure:"ssh_key_env"`
+	SSHKeyPassphraseEnv string `mapstructure:"ssh_key_passphrase_env"`
+	SSHUser             string `mapstructure:"ssh_user"`
+	KnownHostsPath      string `mapstructure:"known_hosts_path"`
+
+	Username    string `mapstructure:"username"`
+	PasswordEnv string `mapstructure:"password_env"`
+	TokenEnv    string `mapstructure:"token_env"`
+}
+
+type HelmConfig struct {
+	Enabled     bool     `mapstructure:"enabled"`
+	ReleaseName string   `mapstructure:"release_name"`
+	ValuesFiles []string `mapstructure:"values_files"`
+	Values      string   `mapstructure:"values"`
 }
 
 type K8sConfig struct {
-	Kubeconfig string `mapstructure:"kubeconfig"`
+	Kubeconfig           string        `mapstructure:"kubeconfig"`
+	DiscoveryRefresh     time.Duration `mapstructure:"discovery_refresh"`
+	IncludeClusterScoped bool          `mapstructure:"include_cluster_scoped"`
+}
+
+type GitConfig struct {
+	CacheDir   string        `mapstructure:"cache_dir"`
+	Depth      int           `mapstructure:"depth"`
+	GCInterval time.Duration `mapstructure:"gc_interval"`
+	InMemory   bool          `mapstructure:"in_memory"`
 }
 
 type WebhookConfig struct {
 	Enabled bool   `mapstructure:"enabled"`
 	Secret  string `mapstructure:"secret"`
 	Port    int    `mapstructure:"port"`
+	// Insecure accepts unsigned deliveries; only meant for local testing.
+	Insecure     bool          `mapstructure:"insecure"`
+	ReplayWindow time.Duration `mapstructure:"replay_window"`
+	RateLimit    float64       `mapstructure:"rate_limit"`
+	RateBurst    int           `mapstructure:"rate_burst"`
+}
+
+type APIConfig struct {
+	// Token authorizes sync, suspend, resume and diff requests. They are
+	// refused while it is empty.
+	Token string `mapstructure:"token"`
+}
+
+type HealthConfig struct {
+	// StuckSyncThreshold fails the liveness probe once a single sync has run
+	// longer than this. Zero disables the check.
+	StuckSyncThreshold time.Duration `mapstructure:"stuck_sync_threshold"`
+}
+
+// LeaderElectionConfig lets several replicas run with only the Lease holder
+// syncing.
+type LeaderElectionConfig struct {
+	Enabled   bool   `mapstructure:"enabled"`
+	LeaseName string `mapstructure:"lease_name"`
+	// LeaseNamespace defaults to the namespace the controller runs in.
+	LeaseNamespace string `mapstructure:"lease_namespace"`
+	// Identity defaults to the hostname, i.e. the pod name.
+	Identity      string        `mapstructure:"identity"`
+	LeaseDuration time.Duration `mapstructure:"lease_duration"`
+	RenewDeadline time.Duration `mapstructure:"renew_deadline"`
+	RetryPeriod   time.Duration `mapstructure:"retry_period"`
 }
 
 func Load() (*Config, error) {
@@ -39,6 +123,21 @@ func Load() (*Config, error) {
 
 	v.SetDefault("webhook.enabled", true)
 	v.SetDefault("webhook.port", 8080)
+	v.SetDefault("webhook.replay_window", 10*time.Minute)
+	v.SetDefault("webhook.rate_limit", 1.0)
+	v.SetDefault("webhook.rate_burst", 10)
+	v.SetDefault("api.token", "")
+	v.SetDefault("health.stuck_sync_threshold", 30*time.Minute)
+	v.SetDefault("leader_election.enabled", false)
+	v.SetDefault("leader_election.lease_name", "gitops-controller")
+	v.SetDefault("leader_election.lease_namespace", "")
+	v.SetDefault("leader_election.identity", "")
+	v.SetDefault("leader_election.lease_duration", 15*time.Second)
+	v.SetDefault("leader_election.renew_deadline", 10*time.Second)
+	v.SetDefault("leader_election.retry_period", 2*time.Second)
+	v.SetDefault("kubernetes.discovery_refresh", 5*time.Minute)
+	v.SetDefault("git.cache_dir", "/tmp/gitops-repos")
+	v.SetDefault("git.gc_interval", time.Hour)
 
 	v.SetConfigName("config")
 	v.AddConfigPath(".")
@@ -65,6 +164,33 @@ func Load() (*Config, error) {
 		return nil, fmt.Errorf("config error: no 'repositories' defined")
 	}
 
+	if cfg.Webhook.Enabled && cfg.Webhook.Secret == "" && !cfg.Webhook.Insecure {
+		return nil, fmt.Errorf("config error: 'webhook.secret' is required unless 'webhook.insecure' is set")
+	}
+
+	if le := cfg.LeaderElection; le.Enabled {
+		if le.LeaseName == "" {
+			return nil, fmt.Errorf("config error: 'leader_election.lease_name' is required")
+		}
+		if le.RetryPeriod <= 0 || le.RenewDeadline <= le.RetryPeriod*6/5 || le.LeaseDuration <= le.RenewDeadline {
+			return nil, fmt.Errorf("config error: leader election requires lease_duration > renew_deadline > 1.2 * retry_period > 0")
+		}
+	}
+
+	seen := make(map[string]struct{})
+	for _, repo := range cfg.Repositories {
+		if errs := validation.IsValidLabelValue(repo.Name); repo.Name == "" || len(errs) > 0 {
+			return nil, fmt.Errorf("config error: invalid repository name %q: %s", repo.Name, strings.Join(errs, "; "))
+		}
+		if errs := validation.IsDNS1123Subdomain(InventoryNamePrefix + repo.Name); len(errs) > 0 {
+			return nil, fmt.Errorf("config error: invalid repository name %q: inventory ConfigMap name: %s", repo.Name, strings.Join(errs, "; "))
+		}
+		if _, ok := seen[repo.Name]; ok {
+			return nil, fmt.Errorf("config error: duplicate repository name %q", repo.Name)
+		}
+		seen[repo.Name] = struct{}{}
+	}
+
 	log.Info("Configuration loaded successfully.")
 	return &cfg, nil
 }
@@ -0,0 +1,115 @@
+package git
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+
+	"github.com/MyoMyatMin/gitops-controller/internal/config"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
+)
+
+const (
+	defaultSSHUser   = "git"
+	defaultTokenUser = "git"
+)
+
+// NewAuth builds the go-git auth method for a repository. It returns nil when
+// no credentials are configured so public repositories keep working.
+func NewAuth(cfg config.GitAuthConfig) (transport.AuthMethod, error) {
+	sshConfigured := cfg.SSHKeyPath != "" || cfg.SSHKeyEnv != ""
+	httpConfigured := cfg.Username != "" || cfg.PasswordEnv != "" || cfg.TokenEnv != ""
+
+	switch {
+	case sshConfigured && httpConfigured:
+		return nil, fmt.Errorf("both SSH key and HTTPS credentials configured; choose one")
+	case sshConfigured:
+		return newSSHAuth(cfg)
+	case httpConfigured:
+		return newHTTPAuth(cfg)
+	}
+	return nil, nil
+}
+
+func newSSHAuth(cfg config.GitAuthConfig) (transport.AuthMethod, error) {
+	user := cfg.SSHUser
+	if user == "" {
+		user = defaultSSHUser
+	}
+
+	passphrase, err := readSecretEnv(cfg.SSHKeyPassphraseEnv)
+	if err != nil {
+		return nil, err
+	}
+
+	var auth *ssh.PublicKeys
+	if cfg.SSHKeyPath != "" {
+		auth, err = ssh.NewPublicKeysFromFile(user, cfg.SSHKeyPath, passphrase)
+	} else {
+		var key string
+		key, err = readSecretEnv(cfg.SSHKeyEnv)
+		if err == nil && key == "" {
+			err = fmt.Errorf("environment variable %s is empty", cfg.SSHKeyEnv)
+		}
+		if err == nil {
+			auth, err = ssh.NewPublicKeys(user, []byte(key), passphrase)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error loading SSH private key: %w", err)
+	}
+
+	var knownHosts []string
+	if cfg.KnownHostsPath != "" {
+		knownHosts = append(knownHosts, cfg.KnownHostsPath)
+	}
+	callback, err := ssh.NewKnownHostsCallback(knownHosts...)
+	if err != nil {
+		return nil, fmt.Errorf("error loading known_hosts: %w", err)
+	}
+	auth.HostKeyCallback = callback
+
+	return auth, nil
+}
+
+func newHTTPAuth(cfg config.GitAuthConfig) (transport.AuthMethod, error) {
+	if cfg.TokenEnv != "" {
+		token, err := readSecretEnv(cfg.TokenEnv)
+		if err != nil {
+			return nil, err
+		}
+		username := cfg.Username
+		if username == "" {
+			username = defaultTokenUser
+		}
+		return &http.BasicAuth{Username: username, Password: token}, nil
+	}
+
+	password, err := readSecretEnv(cfg.PasswordEnv)
+	if err != nil {
+		return nil, err
+	}
+	return &http.BasicAuth{Username: cfg.Username, Password: password}, nil
+}
+
+func readSecretEnv(name string) (string, error) {
+	if name == "" {
+		return "", nil
+	}
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s is not set", name)
+	}
+	return value, nil
+}
+
+// redactURL hides any password embedded in a repository URL before it is logged.
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.User == nil {
+		return rawURL
+	}
+	return u.Redacted()
+}
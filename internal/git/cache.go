@@ -0,0 +1,65 @@
+package git
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/MyoMyatMin/gitops-controller/internal/log"
+)
+
+// CleanCache removes cached clones under cacheDir that do not belong to any
+// of the given repository names. Only directories that look like git
+// repositories are removed.
+func CleanCache(cacheDir string, keep []string) {
+	entries, err := os.ReadDir(cacheDir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Warnf("Could not read clone cache %s: %v", cacheDir, err)
+		}
+		return
+	}
+
+	keepSet := make(map[string]struct{}, len(keep))
+	for _, name := range keep {
+		keepSet[name] = struct{}{}
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		if _, ok := keepSet[entry.Name()]; ok {
+			continue
+		}
+
+		path := filepath.Join(cacheDir, entry.Name())
+		if _, err := os.Stat(filepath.Join(path, ".git")); err != nil {
+			continue
+		}
+
+		log.Infof("Removing stale cached clone %s", path)
+		if err := os.RemoveAll(path); err != nil {
+			log.Warnf("Could not remove stale cached clone %s: %v", path, err)
+		}
+	}
+}
+
+func StartCacheCleanup(cacheDir string, keep []string, interval time.Duration, stopCh <-chan struct{}) {
+	CleanCache(cacheDir, keep)
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			CleanCache(cacheDir, keep)
+		case <-stopCh:
+			return
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package git
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+type RefType string
+
+const (
+	RefBranch RefType = "branch"
+	RefTag    RefType = "tag"
+	RefSemver RefType = "semver"
+	RefCommit RefType = "commit"
+)
+
+// RefSpec describes what a repository tracks: a branch head, an exact tag,
+// the highest tag matching a semver constraint, or a pinned commit.
+type RefSpec struct {
+	Type  RefType
+	Value string
+}
+
+// ParseRef parses specs of the form "branch:main", "tag:v1.2.0",
+// "semver:>=1.4.0 <2.0.0" or "commit:<sha>". A spec without a prefix is
+// treated as a branch name.
+func ParseRef(spec string) (RefSpec, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return RefSpec{}, fmt.Errorf("empty ref")
+	}
+
+	refType, value, found := strings.Cut(spec, ":")
+	if !found {
+		return RefSpec{Type: RefBranch, Value: spec}, nil
+	}
+
+	ref := RefSpec{Type: RefType(refType), Value: strings.TrimSpace(value)}
+	if ref.Value == "" {
+		return RefSpec{}, fmt.Errorf("ref %q has no value", spec)
+	}
+
+	switch ref.Type {
+	case RefBranch, RefTag, RefCommit:
+	case RefSemver:
+		if _, err := semver.NewConstraint(ref.Value); err != nil {
+			return RefSpec{}, fmt.Errorf("invalid semver constraint %q: %w", ref.Value, err)
+		}
+	default:
+		return RefSpec{}, fmt.Errorf("unknown ref type %q", refType)
+	}
+	return ref, nil
+}
+
+func (r RefSpec) String() string {
+	return fmt.Sprintf("%s:%s", r.Type, r.Value)
+}
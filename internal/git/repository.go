@@ -1,12 +1,21 @@
 package git
 
 import (
+	"context"
 	"fmt"
+	"io/fs"
 	"os"
+	"sort"
 
+	"github.com/Masterminds/semver/v3"
 	"github.com/MyoMyatMin/gitops-controller/internal/log"
+	"github.com/go-git/go-billy/v5/helper/iofs"
+	"github.com/go-git/go-billy/v5/memfs"
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/storage/memory"
 	"github.com/sirupsen/logrus"
 )
 
@@ -14,28 +23,65 @@ type Repository struct {
 	URL       string
 	LocalPath string
 	Branch    string
+	Ref       RefSpec
+	Auth      transport.AuthMethod
+	Verifier  *Verifier
+	Depth     int
+	InMemory  bool
+
+	memRepo     *git.Repository
+	resolvedRef string
+}
+
+func (r *Repository) ref() RefSpec {
+	if r.Ref.Type == "" {
+		return RefSpec{Type: RefBranch, Value: r.Branch}
+	}
+	return r.Ref
 }
 
-func (r *Repository) Clone() error {
-	if _, err := os.Stat(r.LocalPath); !os.IsNotExist(err) {
-		repo, err := git.PlainOpen(r.LocalPath)
+func (r *Repository) Clone(ctx context.Context) error {
+	if r.InMemory {
+		if r.memRepo != nil {
+			log.Info("Repository already cloned in memory")
+			return nil
+		}
+	} else if _, err := os.Stat(r.LocalPath); !os.IsNotExist(err) {
+		err := r.checkCachedClone()
 		if err == nil {
-			remote, err := repo.Remote("origin")
-			if err == nil && len(remote.Config().URLs) > 0 && remote.Config().URLs[0] == r.URL {
-				log.Infof("Repository already cloned at %s", r.LocalPath)
-				return nil
-			}
+			log.Infof("Reusing cached clone at %s", r.LocalPath)
+			return nil
+		}
+
+		log.Warnf("Discarding cached clone at %s: %v", r.LocalPath, err)
+		if err := os.RemoveAll(r.LocalPath); err != nil {
+			return fmt.Errorf("error removing cached clone at %s: %w", r.LocalPath, err)
 		}
 	}
 
-	log.Infof("Cloning repository %s to %s...", r.URL, r.LocalPath)
+	ref := r.ref()
+	cloneOptions := &git.CloneOptions{
+		URL:      r.URL,
+		Auth:     r.Auth,
+		Depth:    r.Depth,
+		Progress: log.Logger.WriterLevel(logrus.DebugLevel),
+	}
+	if ref.Type == RefBranch {
+		cloneOptions.ReferenceName = plumbing.NewBranchReferenceName(ref.Value)
+		cloneOptions.SingleBranch = true
+	} else {
+		cloneOptions.Tags = git.AllTags
+	}
 
-	_, err := git.PlainClone(r.LocalPath, false, &git.CloneOptions{
-		URL:           r.URL,
-		ReferenceName: plumbing.NewBranchReferenceName(r.Branch),
-		Progress:      log.Logger.WriterLevel(logrus.DebugLevel),
-		SingleBranch:  true,
-	})
+	var repo *git.Repository
+	var err error
+	if r.InMemory {
+		log.Infof("Cloning repository %s into memory...", redactURL(r.URL))
+		repo, err = git.CloneContext(ctx, memory.NewStorage(), memfs.New(), cloneOptions)
+	} else {
+		log.Infof("Cloning repository %s to %s...", redactURL(r.URL), r.LocalPath)
+		repo, err = git.PlainCloneContext(ctx, r.LocalPath, false, cloneOptions)
+	}
 
 	if err != nil {
 		if err == git.ErrRepositoryAlreadyExists {
@@ -47,54 +93,223 @@ func (r *Repository) Clone() error {
 	}
 
 	log.Info("Repository cloned successfully.")
+	if r.InMemory {
+		r.memRepo = repo
+	}
+
+	if ref.Type != RefBranch {
+		return r.Pull(ctx)
+	}
 	return nil
 }
 
-func (r *Repository) Pull() error {
-	log.Info("Pulling latest changes...")
+func (r *Repository) open() (*git.Repository, error) {
+	if r.InMemory {
+		if r.memRepo == nil {
+			return nil, fmt.Errorf("in-memory repository has not been cloned")
+		}
+		return r.memRepo, nil
+	}
+	return git.PlainOpen(r.LocalPath)
+}
+
+// Cloned reports whether a clone exists to work with.
+func (r *Repository) Cloned() bool {
+	_, err := r.open()
+	return err == nil
+}
+
+// FS returns the checked-out worktree as a read-only filesystem.
+func (r *Repository) FS() (fs.FS, error) {
+	if !r.InMemory {
+		return os.DirFS(r.LocalPath), nil
+	}
+
+	repo, err := r.open()
+	if err != nil {
+		return nil, err
+	}
+	w, err := repo.Worktree()
+	if err != nil {
+		return nil, fmt.Errorf("error getting worktree: %w", err)
+	}
+	return iofs.New(w.Filesystem), nil
+}
+
+// checkCachedClone returns an error explaining why the clone at LocalPath
+// cannot be reused, or nil if it points at the same remote and ref and its
+// HEAD commit is readable.
+func (r *Repository) checkCachedClone() error {
 	repo, err := git.PlainOpen(r.LocalPath)
 	if err != nil {
-		log.Errorf("error opening repository at %s: %v", r.LocalPath, err)
-		return fmt.Errorf("error opening repository at %s: %w", r.LocalPath, err)
+		return fmt.Errorf("cannot open repository: %w", err)
 	}
 
-	w, err := repo.Worktree()
+	remote, err := repo.Remote("origin")
 	if err != nil {
-		log.Errorf("error getting worktree: %v", err)
-		return fmt.Errorf("error getting worktree: %w", err)
+		return fmt.Errorf("cannot read remote: %w", err)
+	}
+	if urls := remote.Config().URLs; len(urls) == 0 || urls[0] != r.URL {
+		return fmt.Errorf("remote URL does not match")
 	}
 
-	err = w.Pull(&git.PullOptions{
-		RemoteName:    "origin",
-		ReferenceName: plumbing.NewBranchReferenceName(r.Branch),
-		Progress:      log.Logger.WriterLevel(logrus.DebugLevel),
-	})
+	if ref := r.ref(); ref.Type == RefBranch {
+		branch := plumbing.NewBranchReferenceName(ref.Value)
+		tracked := false
+		for _, spec := range remote.Config().Fetch {
+			if spec.Match(branch) {
+				tracked = true
+				break
+			}
+		}
+		if !tracked {
+			return fmt.Errorf("clone does not track branch %s", ref.Value)
+		}
+	}
 
-	if err != nil && err != git.NoErrAlreadyUpToDate {
+	head, err := repo.Head()
+	if err != nil {
+		return fmt.Errorf("cannot resolve HEAD: %w", err)
+	}
+	commit, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		return fmt.Errorf("cannot read HEAD commit: %w", err)
+	}
+	if _, err := commit.Tree(); err != nil {
+		return fmt.Errorf("cannot read HEAD tree: %w", err)
+	}
+	return nil
+}
+
+// Pull fetches the remote, resolves the configured ref and checks the
+// resolved commit out into the worktree.
+func (r *Repository) Pull(ctx context.Context) error {
+	log.Info("Pulling latest changes...")
+	repo, err := r.open()
+	if err != nil {
+		log.Errorf("error opening repository at %s: %v", r.LocalPath, err)
+		return fmt.Errorf("error opening repository at %s: %w", r.LocalPath, err)
+	}
+
+	if err := r.fetch(ctx, repo); err != nil {
 		log.Errorf("error pulling changes: %v", err)
 		return fmt.Errorf("error pulling changes: %w", err)
 	}
 
-	log.Info("Pull successful. Repository is up-to-date.")
+	hash, resolvedRef, err := r.resolve(repo)
+	if err != nil {
+		log.Errorf("error resolving ref %s: %v", r.ref(), err)
+		return fmt.Errorf("error resolving ref %s: %w", r.ref(), err)
+	}
+
+	if r.Verifier != nil {
+		if err := r.verify(repo, hash, resolvedRef); err != nil {
+			return err
+		}
+	}
+
+	if err := r.checkout(repo, hash); err != nil {
+		return err
+	}
+	r.resolvedRef = resolvedRef
+
+	log.WithFields(logrus.Fields{
+		"ref":    resolvedRef,
+		"commit": hash.String(),
+	}).Info("Pull successful. Repository is up-to-date.")
 	return nil
 }
 
-func (r *Repository) HasChanges() (bool, error) {
-	repo, err := git.PlainOpen(r.LocalPath)
+// CheckoutCommit checks out a specific commit from history, fetching once if
+// it is not yet known locally. The commit is verified like any pulled ref.
+func (r *Repository) CheckoutCommit(ctx context.Context, sha string) error {
+	log.Infof("Checking out commit %s...", sha)
+	repo, err := r.open()
 	if err != nil {
-		return false, fmt.Errorf("error opening repo: %w", err)
+		log.Errorf("error opening repository at %s: %v", r.LocalPath, err)
+		return fmt.Errorf("error opening repository at %s: %w", r.LocalPath, err)
 	}
 
-	remote, err := repo.Remote("origin")
+	hash, err := repo.ResolveRevision(plumbing.Revision(sha))
 	if err != nil {
-		return false, fmt.Errorf("error getting remote: %w", err)
+		if err := r.fetch(ctx, repo); err != nil {
+			log.Errorf("error fetching changes: %v", err)
+			return fmt.Errorf("error fetching changes: %w", err)
+		}
+		hash, err = repo.ResolveRevision(plumbing.Revision(sha))
 	}
+	if err != nil {
+		log.Errorf("error resolving commit %s: %v", sha, err)
+		return fmt.Errorf("error resolving commit %s: %w", sha, err)
+	}
+	resolvedRef := RefSpec{Type: RefCommit, Value: hash.String()}.String()
 
-	err = remote.Fetch(&git.FetchOptions{
-		RemoteName: "origin",
-	})
+	if r.Verifier != nil {
+		if err := r.verify(repo, *hash, resolvedRef); err != nil {
+			return err
+		}
+	}
 
-	if err != nil && err != git.NoErrAlreadyUpToDate {
+	if err := r.checkout(repo, *hash); err != nil {
+		return err
+	}
+	r.resolvedRef = resolvedRef
+
+	log.WithFields(logrus.Fields{
+		"ref":    resolvedRef,
+		"commit": hash.String(),
+	}).Info("Checkout successful.")
+	return nil
+}
+
+// Resolve fetches the remote and returns the commit a revision points to
+// without checking it out. An empty revision resolves the tracked ref.
+func (r *Repository) Resolve(ctx context.Context, revision string) (string, string, error) {
+	repo, err := r.open()
+	if err != nil {
+		return "", "", fmt.Errorf("error opening repository at %s: %w", r.LocalPath, err)
+	}
+
+	if err := r.fetch(ctx, repo); err != nil {
+		return "", "", fmt.Errorf("error fetching changes: %w", err)
+	}
+
+	if revision == "" {
+		hash, resolvedRef, err := r.resolve(repo)
+		if err != nil {
+			return "", "", fmt.Errorf("error resolving ref %s: %w", r.ref(), err)
+		}
+		return hash.String(), resolvedRef, nil
+	}
+
+	hash, err := repo.ResolveRevision(plumbing.Revision(revision))
+	if err != nil {
+		return "", "", fmt.Errorf("error resolving revision %s: %w", revision, err)
+	}
+	return hash.String(), RefSpec{Type: RefCommit, Value: hash.String()}.String(), nil
+}
+
+// CommitFS returns a read-only view of the files at a commit.
+func (r *Repository) CommitFS(sha string) (fs.FS, error) {
+	repo, err := r.open()
+	if err != nil {
+		return nil, fmt.Errorf("error opening repository at %s: %w", r.LocalPath, err)
+	}
+
+	tree, err := commitTree(repo, sha)
+	if err != nil {
+		return nil, err
+	}
+	return treeFS{tree: tree}, nil
+}
+
+func (r *Repository) HasChanges(ctx context.Context) (bool, error) {
+	repo, err := r.open()
+	if err != nil {
+		return false, fmt.Errorf("error opening repo: %w", err)
+	}
+
+	if err := r.fetch(ctx, repo); err != nil {
 		return false, fmt.Errorf("error fetching: %w", err)
 	}
 
@@ -103,16 +318,16 @@ func (r *Repository) HasChanges() (bool, error) {
 		return false, fmt.Errorf("error getting HEAD: %w", err)
 	}
 
-	remoteRefName := plumbing.NewRemoteReferenceName("origin", r.Branch)
-	remoteRef, err := repo.Reference(remoteRefName, true)
+	remoteHash, resolvedRef, err := r.resolve(repo)
 	if err != nil {
-		return false, fmt.Errorf("error getting remote ref %s: %w", remoteRefName, err)
+		return false, fmt.Errorf("error resolving ref %s: %w", r.ref(), err)
 	}
 
-	if headRef.Hash() != remoteRef.Hash() {
+	if headRef.Hash() != remoteHash {
 		log.WithFields(logrus.Fields{
+			"ref":        resolvedRef,
 			"local_sha":  headRef.Hash().String(),
-			"remote_sha": remoteRef.Hash().String(),
+			"remote_sha": remoteHash.String(),
 		}).Info("Git changes detected (hashes differ)")
 		return true, nil
 	}
@@ -121,7 +336,7 @@ func (r *Repository) HasChanges() (bool, error) {
 }
 
 func (r *Repository) GetLatestCommit() (string, error) {
-	repo, err := git.PlainOpen(r.LocalPath)
+	repo, err := r.open()
 	if err != nil {
 		log.Errorf("error opening repository at %s: %v", r.LocalPath, err)
 		return "", fmt.Errorf("error opening repository at %s: %w", r.LocalPath, err)
@@ -137,3 +352,191 @@ func (r *Repository) GetLatestCommit() (string, error) {
 	log.Infof("Latest commit SHA: %s", commitSHA)
 	return commitSHA, nil
 }
+
+// ChangedFiles returns the paths added, modified or removed between two commits.
+func (r *Repository) ChangedFiles(fromSHA, toSHA string) ([]string, error) {
+	repo, err := r.open()
+	if err != nil {
+		return nil, fmt.Errorf("error opening repository at %s: %w", r.LocalPath, err)
+	}
+
+	fromTree, err := commitTree(repo, fromSHA)
+	if err != nil {
+		return nil, err
+	}
+	toTree, err := commitTree(repo, toSHA)
+	if err != nil {
+		return nil, err
+	}
+
+	changes, err := object.DiffTree(fromTree, toTree)
+	if err != nil {
+		return nil, fmt.Errorf("error diffing %s..%s: %w", fromSHA, toSHA, err)
+	}
+
+	seen := make(map[string]struct{})
+	var files []string
+	for _, change := range changes {
+		for _, name := range []string{change.From.Name, change.To.Name} {
+			if _, ok := seen[name]; name == "" || ok {
+				continue
+			}
+			seen[name] = struct{}{}
+			files = append(files, name)
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// ResolvedRef returns the concrete ref checked out by the last Pull, e.g.
+// "tag:v1.4.2" for a semver spec.
+func (r *Repository) ResolvedRef() string {
+	if r.resolvedRef == "" {
+		return r.ref().String()
+	}
+	return r.resolvedRef
+}
+
+func (r *Repository) fetch(ctx context.Context, repo *git.Repository) error {
+	fetchOptions := &git.FetchOptions{
+		RemoteName: "origin",
+		Auth:       r.Auth,
+		Depth:      r.Depth,
+		Progress:   log.Logger.WriterLevel(logrus.DebugLevel),
+	}
+	if r.ref().Type != RefBranch {
+		fetchOptions.Tags = git.AllTags
+		fetchOptions.Force = true
+	}
+
+	err := repo.FetchContext(ctx, fetchOptions)
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		return err
+	}
+	return nil
+}
+
+func (r *Repository) resolve(repo *git.Repository) (plumbing.Hash, string, error) {
+	ref := r.ref()
+
+	switch ref.Type {
+	case RefBranch:
+		remoteRef, err := repo.Reference(plumbing.NewRemoteReferenceName("origin", ref.Value), true)
+		if err != nil {
+			return plumbing.ZeroHash, "", err
+		}
+		return remoteRef.Hash(), ref.String(), nil
+
+	case RefTag:
+		hash, err := resolveTag(repo, ref.Value)
+		return hash, ref.String(), err
+
+	case RefSemver:
+		tag, err := highestMatchingTag(repo, ref.Value)
+		if err != nil {
+			return plumbing.ZeroHash, "", err
+		}
+		hash, err := resolveTag(repo, tag)
+		return hash, RefSpec{Type: RefTag, Value: tag}.String(), err
+
+	case RefCommit:
+		hash, err := repo.ResolveRevision(plumbing.Revision(ref.Value))
+		if err != nil {
+			return plumbing.ZeroHash, "", err
+		}
+		return *hash, RefSpec{Type: RefCommit, Value: hash.String()}.String(), nil
+	}
+
+	return plumbing.ZeroHash, "", fmt.Errorf("unknown ref type %q", ref.Type)
+}
+
+func (r *Repository) checkout(repo *git.Repository, hash plumbing.Hash) error {
+	w, err := repo.Worktree()
+	if err != nil {
+		log.Errorf("error getting worktree: %v", err)
+		return fmt.Errorf("error getting worktree: %w", err)
+	}
+
+	if err := w.Checkout(&git.CheckoutOptions{Hash: hash, Force: true}); err != nil {
+		log.Errorf("error checking out %s: %v", hash, err)
+		return fmt.Errorf("error checking out %s: %w", hash, err)
+	}
+	return nil
+}
+
+func (r *Repository) verify(repo *git.Repository, hash plumbing.Hash, resolvedRef string) error {
+	logFields := logrus.Fields{
+		"ref":    resolvedRef,
+		"commit": hash.String(),
+	}
+
+	commit, err := repo.CommitObject(hash)
+	if err != nil {
+		return fmt.Errorf("error reading commit %s: %w", hash, err)
+	}
+
+	signer, err := r.Verifier.Verify(commit)
+	if err != nil {
+		log.WithFields(logFields).Errorf("Refusing to check out commit: %v", err)
+		return err
+	}
+
+	logFields["signer"] = signer
+	log.WithFields(logFields).Info("Commit signature verified")
+	return nil
+}
+
+func commitTree(repo *git.Repository, sha string) (*object.Tree, error) {
+	commit, err := repo.CommitObject(plumbing.NewHash(sha))
+	if err != nil {
+		return nil, fmt.Errorf("error reading commit %s: %w", sha, err)
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, fmt.Errorf("error reading tree of commit %s: %w", sha, err)
+	}
+	return tree, nil
+}
+
+func resolveTag(repo *git.Repository, tag string) (plumbing.Hash, error) {
+	hash, err := repo.ResolveRevision(plumbing.Revision(plumbing.NewTagReferenceName(tag)))
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("error resolving tag %s: %w", tag, err)
+	}
+	return *hash, nil
+}
+
+func highestMatchingTag(repo *git.Repository, constraint string) (string, error) {
+	c, err := semver.NewConstraint(constraint)
+	if err != nil {
+		return "", fmt.Errorf("invalid semver constraint %q: %w", constraint, err)
+	}
+
+	tags, err := repo.Tags()
+	if err != nil {
+		return "", fmt.Errorf("error listing tags: %w", err)
+	}
+
+	var best *semver.Version
+	var bestTag string
+	err = tags.ForEach(func(ref *plumbing.Reference) error {
+		name := ref.Name().Short()
+		v, err := semver.NewVersion(name)
+		if err != nil || !c.Check(v) {
+			return nil
+		}
+		if best == nil || v.GreaterThan(best) {
+			best, bestTag = v, name
+		}
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("error iterating tags: %w", err)
+	}
+
+	if best == nil {
+		return "", fmt.Errorf("no tag matches constraint %q", constraint)
+	}
+	return bestTag, nil
+}
@@ -0,0 +1,138 @@
+package git
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"path"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// treeFS is a read-only fs.FS over a commit tree, so manifests can be read at
+// any commit without touching the worktree. Symlinks and submodules are omitted.
+type treeFS struct {
+	tree *object.Tree
+}
+
+func (t treeFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	if name == "." {
+		return &treeDir{info: treeFileInfo{name: ".", mode: fs.ModeDir | 0o755}, tree: t.tree}, nil
+	}
+
+	entry, err := t.tree.FindEntry(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+
+	info, ok := entryInfo(t.tree, name, entry)
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+
+	if info.IsDir() {
+		subtree, err := t.tree.Tree(name)
+		if err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+		return &treeDir{info: info, tree: subtree}, nil
+	}
+
+	file, err := t.tree.TreeEntryFile(entry)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	reader, err := file.Reader()
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: err}
+	}
+	return &treeFile{info: info, Reader: bytes.NewReader(data)}, nil
+}
+
+// entryInfo describes a tree entry, reporting ok=false for entry types that
+// are not exposed.
+func entryInfo(tree *object.Tree, name string, entry *object.TreeEntry) (treeFileInfo, bool) {
+	base := path.Base(name)
+	switch entry.Mode {
+	case filemode.Dir:
+		return treeFileInfo{name: base, mode: fs.ModeDir | 0o755}, true
+	case filemode.Regular, filemode.Deprecated:
+		size, _ := tree.Size(name)
+		return treeFileInfo{name: base, size: size, mode: 0o644}, true
+	case filemode.Executable:
+		size, _ := tree.Size(name)
+		return treeFileInfo{name: base, size: size, mode: 0o755}, true
+	}
+	return treeFileInfo{}, false
+}
+
+type treeFileInfo struct {
+	name string
+	size int64
+	mode fs.FileMode
+}
+
+func (i treeFileInfo) Name() string       { return i.name }
+func (i treeFileInfo) Size() int64        { return i.size }
+func (i treeFileInfo) Mode() fs.FileMode  { return i.mode }
+func (i treeFileInfo) ModTime() time.Time { return time.Time{} }
+func (i treeFileInfo) IsDir() bool        { return i.mode.IsDir() }
+func (i treeFileInfo) Sys() any           { return nil }
+
+type treeFile struct {
+	*bytes.Reader
+	info treeFileInfo
+}
+
+func (f *treeFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *treeFile) Close() error               { return nil }
+
+type treeDir struct {
+	info    treeFileInfo
+	tree    *object.Tree
+	entries []fs.DirEntry
+	offset  int
+}
+
+func (d *treeDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *treeDir) Close() error               { return nil }
+
+func (d *treeDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: fs.ErrInvalid}
+}
+
+func (d *treeDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if d.entries == nil {
+		d.entries = []fs.DirEntry{}
+		for i := range d.tree.Entries {
+			entry := &d.tree.Entries[i]
+			if info, ok := entryInfo(d.tree, entry.Name, entry); ok {
+				d.entries = append(d.entries, fs.FileInfoToDirEntry(info))
+			}
+		}
+	}
+
+	remaining := d.entries[d.offset:]
+	if n <= 0 {
+		d.offset = len(d.entries)
+		return remaining, nil
+	}
+	if len(remaining) == 0 {
+		return nil, io.EOF
+	}
+	n = min(n, len(remaining))
+	d.offset += n
+	return remaining[:n], nil
+}
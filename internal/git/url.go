@@ -0,0 +1,67 @@
+package git
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// NormalizeURL reduces a clone URL to "host/owner/repo" so that the HTTPS,
+// ssh:// and scp-like (git@host:owner/repo.git) forms of one repository
+// compare equal. Credentials, ports, a trailing ".git" and case are dropped.
+func NormalizeURL(rawURL string) string {
+	s := strings.TrimSpace(rawURL)
+	if !strings.Contains(s, "://") {
+		if i := strings.Index(s, ":"); i > 0 && !strings.Contains(s[:i], "/") {
+			s = "ssh://" + s[:i] + "/" + s[i+1:]
+		}
+	}
+
+	u, err := url.Parse(s)
+	if err != nil || u.Host == "" {
+		return strings.ToLower(strings.TrimSuffix(strings.TrimRight(s, "/"), ".git"))
+	}
+
+	repoPath := strings.TrimSuffix(strings.Trim(u.Path, "/"), ".git")
+	return strings.ToLower(u.Hostname() + "/" + repoPath)
+}
+
+// DisplayURL returns the repository URL with any embedded password hidden.
+func (r *Repository) DisplayURL() string {
+	return redactURL(r.URL)
+}
+
+// TrackedRef returns the configured ref spec, e.g. "semver:>=1.4.0 <2.0.0".
+func (r *Repository) TrackedRef() string {
+	return r.ref().String()
+}
+
+// MatchesPush reports whether a push of fullRef (e.g. "refs/heads/main") to a
+// repository known by any of cloneURLs can move the ref this repository tracks.
+// Tag pushes match tag and semver refs; pinned commits never match.
+func (r *Repository) MatchesPush(cloneURLs []string, fullRef string) bool {
+	if !r.matchesURL(cloneURLs) {
+		return false
+	}
+
+	ref := r.ref()
+	pushed := plumbing.ReferenceName(fullRef)
+	switch {
+	case pushed.IsBranch():
+		return ref.Type == RefBranch && ref.Value == pushed.Short()
+	case pushed.IsTag():
+		return ref.Type == RefSemver || (ref.Type == RefTag && ref.Value == pushed.Short())
+	}
+	return false
+}
+
+func (r *Repository) matchesURL(cloneURLs []string) bool {
+	own := NormalizeURL(r.URL)
+	for _, u := range cloneURLs {
+		if u != "" && NormalizeURL(u) == own {
+			return true
+		}
+	}
+	return false
+}
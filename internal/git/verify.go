@@ -0,0 +1,186 @@
+package git
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/MyoMyatMin/gitops-controller/internal/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"golang.org/x/crypto/ssh"
+)
+
+var ErrUnverifiedCommit = errors.New("commit signature is missing or untrusted")
+
+const (
+	sshSigMagic     = "SSHSIG"
+	sshSigNamespace = "git"
+	sshSigPEMType   = "SSH SIGNATURE"
+)
+
+// Verifier checks commit signatures against a set of trusted armored GPG
+// keyrings and SSH signing keys.
+type Verifier struct {
+	gpgKeyRings []string
+	sshKeys     []ssh.PublicKey
+}
+
+// NewVerifier loads the trusted keys for a repository. It returns nil when
+// signature verification is disabled.
+func NewVerifier(cfg config.SignatureConfig) (*Verifier, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+
+	v := &Verifier{}
+	for _, path := range cfg.GPGKeyFiles {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading GPG key file %s: %w", path, err)
+		}
+		v.gpgKeyRings = append(v.gpgKeyRings, string(data))
+	}
+
+	for _, path := range cfg.SSHKeyFiles {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading SSH key file %s: %w", path, err)
+		}
+		for len(bytes.TrimSpace(data)) > 0 {
+			key, _, _, rest, err := ssh.ParseAuthorizedKey(data)
+			if err != nil {
+				return nil, fmt.Errorf("error parsing SSH key file %s: %w", path, err)
+			}
+			v.sshKeys = append(v.sshKeys, key)
+			data = rest
+		}
+	}
+
+	if len(v.gpgKeyRings) == 0 && len(v.sshKeys) == 0 {
+		return nil, fmt.Errorf("signature verification enabled but no trusted keys configured")
+	}
+	return v, nil
+}
+
+// Verify returns a description of the trusted key that signed commit, or an
+// error wrapping ErrUnverifiedCommit.
+func (v *Verifier) Verify(commit *object.Commit) (string, error) {
+	signature := commit.PGPSignature
+	if signature == "" {
+		return "", fmt.Errorf("%w: commit %s is not signed", ErrUnverifiedCommit, commit.Hash)
+	}
+
+	if strings.Contains(signature, "BEGIN "+sshSigPEMType) {
+		signer, err := v.verifySSH(commit, signature)
+		if err != nil {
+			return "", fmt.Errorf("%w: commit %s: %v", ErrUnverifiedCommit, commit.Hash, err)
+		}
+		return signer, nil
+	}
+
+	var lastErr error = errors.New("no trusted GPG keys configured")
+	for _, keyRing := range v.gpgKeyRings {
+		entity, err := commit.Verify(keyRing)
+		if err == nil {
+			for name := range entity.Identities {
+				return "gpg:" + name, nil
+			}
+			return fmt.Sprintf("gpg:%X", entity.PrimaryKey.Fingerprint), nil
+		}
+		lastErr = err
+	}
+	return "", fmt.Errorf("%w: commit %s: %v", ErrUnverifiedCommit, commit.Hash, lastErr)
+}
+
+func (v *Verifier) verifySSH(commit *object.Commit, armored string) (string, error) {
+	block, _ := pem.Decode([]byte(armored))
+	if block == nil || block.Type != sshSigPEMType {
+		return "", errors.New("malformed SSH signature")
+	}
+	if !bytes.HasPrefix(block.Bytes, []byte(sshSigMagic)) {
+		return "", errors.New("SSH signature has invalid magic preamble")
+	}
+
+	var sig struct {
+		Version       uint32
+		PublicKey     []byte
+		Namespace     string
+		Reserved      string
+		HashAlgorithm string
+		Signature     []byte
+		Rest          []byte `ssh:"rest"`
+	}
+	if err := ssh.Unmarshal(block.Bytes[len(sshSigMagic):], &sig); err != nil {
+		return "", fmt.Errorf("error decoding SSH signature: %w", err)
+	}
+	if sig.Version != 1 {
+		return "", fmt.Errorf("unsupported SSH signature version %d", sig.Version)
+	}
+	if sig.Namespace != sshSigNamespace {
+		return "", fmt.Errorf("SSH signature namespace is %q, expected %q", sig.Namespace, sshSigNamespace)
+	}
+
+	publicKey, err := ssh.ParsePublicKey(sig.PublicKey)
+	if err != nil {
+		return "", fmt.Errorf("error parsing SSH signing key: %w", err)
+	}
+	if !v.trustsSSHKey(publicKey) {
+		return "", fmt.Errorf("SSH signing key %s is not trusted", ssh.FingerprintSHA256(publicKey))
+	}
+
+	var h hash.Hash
+	switch sig.HashAlgorithm {
+	case "sha256":
+		h = sha256.New()
+	case "sha512":
+		h = sha512.New()
+	default:
+		return "", fmt.Errorf("unsupported SSH signature hash algorithm %q", sig.HashAlgorithm)
+	}
+
+	encoded := &plumbing.MemoryObject{}
+	if err := commit.EncodeWithoutSignature(encoded); err != nil {
+		return "", err
+	}
+	reader, err := encoded.Reader()
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(h, reader); err != nil {
+		return "", err
+	}
+
+	signedData := append([]byte(sshSigMagic), ssh.Marshal(struct {
+		Namespace     string
+		Reserved      string
+		HashAlgorithm string
+		Hash          []byte
+	}{sig.Namespace, sig.Reserved, sig.HashAlgorithm, h.Sum(nil)})...)
+
+	signature := new(ssh.Signature)
+	if err := ssh.Unmarshal(sig.Signature, signature); err != nil {
+		return "", fmt.Errorf("error decoding SSH signature blob: %w", err)
+	}
+	if err := publicKey.Verify(signedData, signature); err != nil {
+		return "", fmt.Errorf("SSH signature does not match: %w", err)
+	}
+
+	return "ssh:" + ssh.FingerprintSHA256(publicKey), nil
+}
+
+func (v *Verifier) trustsSSHKey(key ssh.PublicKey) bool {
+	for _, trusted := range v.sshKeys {
+		if bytes.Equal(trusted.Marshal(), key.Marshal()) {
+			return true
+		}
+	}
+	return false
+}
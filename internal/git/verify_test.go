@@ -0,0 +1,271 @@
+package git
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/pem"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MyoMyatMin/gitops-controller/internal/config"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"golang.org/x/crypto/ssh"
+)
+
+func newTestCommit(message string) *object.Commit {
+	when := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	sig := object.Signature{Name: "Test", Email: "test@example.com", When: when}
+	return &object.Commit{
+		Hash:      plumbing.NewHash("0123456789abcdef0123456789abcdef01234567"),
+		Author:    sig,
+		Committer: sig,
+		Message:   message,
+		TreeHash:  plumbing.NewHash("4b825dc642cb6eb9a060e54bf8d69288fbee4904"),
+	}
+}
+
+func commitPayload(t *testing.T, commit *object.Commit) []byte {
+	t.Helper()
+	encoded := &plumbing.MemoryObject{}
+	if err := commit.EncodeWithoutSignature(encoded); err != nil {
+		t.Fatalf("encoding commit: %v", err)
+	}
+	reader, err := encoded.Reader()
+	if err != nil {
+		t.Fatalf("reading commit: %v", err)
+	}
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("reading commit: %v", err)
+	}
+	return data
+}
+
+func newGPGEntity(t *testing.T, name string) *openpgp.Entity {
+	t.Helper()
+	entity, err := openpgp.NewEntity(name, "", strings.ToLower(name)+"@example.com", nil)
+	if err != nil {
+		t.Fatalf("generating GPG key: %v", err)
+	}
+	return entity
+}
+
+func armoredPublicKey(t *testing.T, entity *openpgp.Entity) string {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := armor.Encode(&buf, openpgp.PublicKeyType, nil)
+	if err != nil {
+		t.Fatalf("armoring GPG key: %v", err)
+	}
+	if err := entity.Serialize(w); err != nil {
+		t.Fatalf("serializing GPG key: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("armoring GPG key: %v", err)
+	}
+	return buf.String()
+}
+
+func signGPG(t *testing.T, commit *object.Commit, entity *openpgp.Entity) {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := openpgp.ArmoredDetachSign(&buf, entity, bytes.NewReader(commitPayload(t, commit)), nil); err != nil {
+		t.Fatalf("signing commit: %v", err)
+	}
+	commit.PGPSignature = buf.String()
+}
+
+func newSSHSigner(t *testing.T) ssh.Signer {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generating SSH key: %v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatalf("creating SSH signer: %v", err)
+	}
+	return signer
+}
+
+// signSSH signs commit the way git does with gpg.format=ssh.
+func signSSH(t *testing.T, commit *object.Commit, signer ssh.Signer, namespace string) {
+	t.Helper()
+	digest := sha512.Sum512(commitPayload(t, commit))
+	signedData := append([]byte(sshSigMagic), ssh.Marshal(struct {
+		Namespace     string
+		Reserved      string
+		HashAlgorithm string
+		Hash          []byte
+	}{namespace, "", "sha512", digest[:]})...)
+
+	signature, err := signer.Sign(rand.Reader, signedData)
+	if err != nil {
+		t.Fatalf("signing commit: %v", err)
+	}
+	blob := append([]byte(sshSigMagic), ssh.Marshal(struct {
+		Version       uint32
+		PublicKey     []byte
+		Namespace     string
+		Reserved      string
+		HashAlgorithm string
+		Signature     []byte
+	}{1, signer.PublicKey().Marshal(), namespace, "", "sha512", ssh.Marshal(signature)})...)
+	commit.PGPSignature = string(pem.EncodeToMemory(&pem.Block{Type: sshSigPEMType, Bytes: blob}))
+}
+
+func TestVerifierVerify(t *testing.T) {
+	trustedGPG := newGPGEntity(t, "Trusted")
+	untrustedGPG := newGPGEntity(t, "Untrusted")
+	trustedSSH := newSSHSigner(t)
+	untrustedSSH := newSSHSigner(t)
+
+	v := &Verifier{
+		gpgKeyRings: []string{armoredPublicKey(t, trustedGPG)},
+		sshKeys:     []ssh.PublicKey{trustedSSH.PublicKey()},
+	}
+
+	tests := []struct {
+		name       string
+		sign       func(*object.Commit)
+		tamper     bool
+		wantSigner string
+	}{
+		{
+			name:       "gpg signed by trusted key",
+			sign:       func(c *object.Commit) { signGPG(t, c, trustedGPG) },
+			wantSigner: "gpg:Trusted <trusted@example.com>",
+		},
+		{
+			name: "gpg signed by untrusted key",
+			sign: func(c *object.Commit) { signGPG(t, c, untrustedGPG) },
+		},
+		{
+			name:   "gpg signature over other content",
+			sign:   func(c *object.Commit) { signGPG(t, c, trustedGPG) },
+			tamper: true,
+		},
+		{
+			name:       "ssh signed by trusted key",
+			sign:       func(c *object.Commit) { signSSH(t, c, trustedSSH, sshSigNamespace) },
+			wantSigner: "ssh:" + ssh.FingerprintSHA256(trustedSSH.PublicKey()),
+		},
+		{
+			name: "ssh signed by untrusted key",
+			sign: func(c *object.Commit) { signSSH(t, c, untrustedSSH, sshSigNamespace) },
+		},
+		{
+			name:   "ssh signature over other content",
+			sign:   func(c *object.Commit) { signSSH(t, c, trustedSSH, sshSigNamespace) },
+			tamper: true,
+		},
+		{
+			name: "ssh signature in another namespace",
+			sign: func(c *object.Commit) { signSSH(t, c, trustedSSH, "file") },
+		},
+		{
+			name: "unsigned",
+			sign: func(*object.Commit) {},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			commit := newTestCommit("Deploy\n")
+			tt.sign(commit)
+			if tt.tamper {
+				commit.Message = "Deploy something else\n"
+			}
+
+			signer, err := v.Verify(commit)
+			if tt.wantSigner == "" {
+				if !errors.Is(err, ErrUnverifiedCommit) {
+					t.Fatalf("Verify() error = %v, want ErrUnverifiedCommit", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Verify() error = %v", err)
+			}
+			if signer != tt.wantSigner {
+				t.Errorf("Verify() signer = %q, want %q", signer, tt.wantSigner)
+			}
+		})
+	}
+}
+
+func writeKeyFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("writing %s: %v", name, err)
+	}
+	return path
+}
+
+func TestNewVerifier(t *testing.T) {
+	first, second := newSSHSigner(t), newSSHSigner(t)
+	gpgEntity := newGPGEntity(t, "Release")
+
+	authorizedKeys := string(ssh.MarshalAuthorizedKey(first.PublicKey())) +
+		"\n# deploy key\n" +
+		strings.TrimSpace(string(ssh.MarshalAuthorizedKey(second.PublicKey()))) + " ci@example.com\n"
+	sshFile := writeKeyFile(t, "allowed_signers", authorizedKeys)
+	gpgFile := writeKeyFile(t, "release.asc", armoredPublicKey(t, gpgEntity))
+
+	t.Run("disabled", func(t *testing.T) {
+		v, err := NewVerifier(config.SignatureConfig{SSHKeyFiles: []string{sshFile}})
+		if err != nil || v != nil {
+			t.Fatalf("NewVerifier() = %v, %v, want nil, nil", v, err)
+		}
+	})
+
+	t.Run("multi-key file", func(t *testing.T) {
+		v, err := NewVerifier(config.SignatureConfig{Enabled: true, GPGKeyFiles: []string{gpgFile}, SSHKeyFiles: []string{sshFile}})
+		if err != nil {
+			t.Fatalf("NewVerifier() error = %v", err)
+		}
+		if len(v.sshKeys) != 2 || len(v.gpgKeyRings) != 1 {
+			t.Fatalf("NewVerifier() loaded %d SSH keys and %d GPG keyrings, want 2 and 1", len(v.sshKeys), len(v.gpgKeyRings))
+		}
+
+		for _, signer := range []ssh.Signer{first, second} {
+			commit := newTestCommit("Deploy\n")
+			signSSH(t, commit, signer, sshSigNamespace)
+			if _, err := v.Verify(commit); err != nil {
+				t.Errorf("Verify() with key %s error = %v", ssh.FingerprintSHA256(signer.PublicKey()), err)
+			}
+		}
+		commit := newTestCommit("Deploy\n")
+		signGPG(t, commit, gpgEntity)
+		if _, err := v.Verify(commit); err != nil {
+			t.Errorf("Verify() with GPG key error = %v", err)
+		}
+	})
+
+	errorTests := []struct {
+		name string
+		cfg  config.SignatureConfig
+	}{
+		{"no keys", config.SignatureConfig{Enabled: true}},
+		{"missing file", config.SignatureConfig{Enabled: true, SSHKeyFiles: []string{filepath.Join(t.TempDir(), "missing")}}},
+		{"invalid SSH key", config.SignatureConfig{Enabled: true, SSHKeyFiles: []string{writeKeyFile(t, "invalid", "ssh-ed25519 not-base64\n")}}},
+	}
+	for _, tt := range errorTests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewVerifier(tt.cfg); err == nil {
+				t.Fatal("NewVerifier() error = nil, want an error")
+			}
+		})
+	}
+}
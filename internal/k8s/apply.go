@@ -9,14 +9,27 @@ import (
 	"github.com/MyoMyatMin/gitops-controller/pkg/manifest"
 	"github.com/sirupsen/logrus"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/types"
 )
 
-func (c *Client) Apply(manifest manifest.Manifest, dryRun bool) error {
+func (c *Client) Apply(ctx context.Context, manifest manifest.Manifest, dryRun bool) error {
+	_, err := c.apply(ctx, manifest, dryRun)
+	return err
+}
+
+// DryRunApply server-side applies manifest without persisting it and returns
+// the object as the API server would store it, defaults and admission
+// mutations included.
+func (c *Client) DryRunApply(ctx context.Context, manifest manifest.Manifest) (*unstructured.Unstructured, error) {
+	return c.apply(ctx, manifest, true)
+}
+
+func (c *Client) apply(ctx context.Context, manifest manifest.Manifest, dryRun bool) (*unstructured.Unstructured, error) {
 	obj := manifest.Object
 	if obj == nil {
 		log.Errorf("manifest object is nil for %s", manifest.Name)
-		return fmt.Errorf("manifest object is nil for %s", manifest.Name)
+		return nil, fmt.Errorf("manifest object is nil for %s", manifest.Name)
 	}
 
 	labels := obj.GetLabels()
@@ -28,13 +41,13 @@ func (c *Client) Apply(manifest manifest.Manifest, dryRun bool) error {
 
 	resourceInterface, err := c.getResourceInterface(manifest)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	data, err := json.Marshal(obj)
 	if err != nil {
 		log.Errorf("error marshaling object to JSON for %s: %v", obj.GetName(), err)
-		return fmt.Errorf("error marshaling object to JSON for %s: %w", obj.GetName(), err)
+		return nil, fmt.Errorf("error marshaling object to JSON for %s: %w", obj.GetName(), err)
 	}
 
 	patchOptions := metav1.PatchOptions{
@@ -55,8 +68,8 @@ func (c *Client) Apply(manifest manifest.Manifest, dryRun bool) error {
 		log.WithFields(logFields).Info("Applying resource")
 	}
 
-	_, err = resourceInterface.Patch(
-		context.TODO(),
+	applied, err := resourceInterface.Patch(
+		ctx,
 		obj.GetName(),
 		types.ApplyPatchType,
 		data,
@@ -65,10 +78,10 @@ func (c *Client) Apply(manifest manifest.Manifest, dryRun bool) error {
 
 	if err != nil {
 		log.WithFields(logFields).Errorf("Error applying resource: %v", err)
-		return err
+		return nil, err
 	}
 
-	return nil
+	return applied, nil
 }
 
 func boolPtr(b bool) *bool {
@@ -5,9 +5,12 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/MyoMyatMin/gitops-controller/internal/config"
 	"github.com/MyoMyatMin/gitops-controller/internal/log"
-	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/discovery"
 	"k8s.io/client-go/discovery/cached/memory"
@@ -19,21 +22,34 @@ import (
 )
 
 const (
-	ManagedByLabel  = "app.kubernetes.io/managed-by"
-	FieldManager    = "gitops-controller"
-	PruneAnnotation = "gitops-controller/prune"
+	ManagedByLabel     = "app.kubernetes.io/managed-by"
+	FieldManager       = "gitops-controller"
+	PruneAnnotation    = "gitops-controller/prune"
+	SyncWaveAnnotation = "gitops-controller/sync-wave"
 )
 
+const defaultDiscoveryRefresh = 5 * time.Minute
+
 type Client struct {
 	clientset *kubernetes.Clientset
 	dynamic   dynamic.Interface
-	mapper    meta.RESTMapper
+	discovery discovery.CachedDiscoveryInterface
+	mapper    *restmapper.DeferredDiscoveryRESTMapper
+
+	discoveryRefresh     time.Duration
+	includeClusterScoped bool
+
+	resourcesMu        sync.Mutex
+	resources          []apiResource
+	resourcesFetchedAt time.Time
+
+	discovered atomic.Bool
 }
 
-func NewClient(kubeconfig string) (*Client, error) {
+func NewClient(cfg config.K8sConfig) (*Client, error) {
 	var config *rest.Config
 	var err error
-	if kubeconfig != "" {
+	if kubeconfig := cfg.Kubeconfig; kubeconfig != "" {
 		config, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
 		if err != nil {
 			return nil, fmt.Errorf("failed to build config from kubeconfig: %v", err)
@@ -73,16 +89,24 @@ func NewClient(kubeconfig string) (*Client, error) {
 
 	mapper := restmapper.NewDeferredDiscoveryRESTMapper(cachedDiscovery)
 
+	discoveryRefresh := cfg.DiscoveryRefresh
+	if discoveryRefresh <= 0 {
+		discoveryRefresh = defaultDiscoveryRefresh
+	}
+
 	return &Client{
-		clientset: clientset,
-		dynamic:   dynamic,
-		mapper:    mapper,
+		clientset:            clientset,
+		dynamic:              dynamic,
+		discovery:            cachedDiscovery,
+		mapper:               mapper,
+		discoveryRefresh:     discoveryRefresh,
+		includeClusterScoped: cfg.IncludeClusterScoped,
 	}, nil
 }
 
-func (c *Client) ListNamespaces() error {
+func (c *Client) ListNamespaces(ctx context.Context) error {
 	log.Info("Attempting to list namespaces...")
-	namespaces, err := c.clientset.CoreV1().Namespaces().List(context.TODO(), metav1.ListOptions{})
+	namespaces, err := c.clientset.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
 	if err != nil {
 		log.Errorf("error listing namespaces: %v", err)
 		return fmt.Errorf("error listing namespaces: %w", err)
@@ -0,0 +1,84 @@
+package k8s
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/MyoMyatMin/gitops-controller/internal/log"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+)
+
+type apiResource struct {
+	gvr        schema.GroupVersionResource
+	kind       string
+	namespaced bool
+}
+
+// CheckDiscovery verifies that the API server's resources can be discovered.
+// Once it has succeeded it is not repeated.
+func (c *Client) CheckDiscovery() error {
+	if c.discovered.Load() {
+		return nil
+	}
+	if _, err := c.listableResources(); err != nil {
+		return err
+	}
+	c.discovered.Store(true)
+	return nil
+}
+
+func (c *Client) listableResources() ([]apiResource, error) {
+	c.resourcesMu.Lock()
+	defer c.resourcesMu.Unlock()
+
+	if c.resources != nil && time.Since(c.resourcesFetchedAt) < c.discoveryRefresh {
+		return c.resources, nil
+	}
+
+	if c.resources != nil {
+		c.discovery.Invalidate()
+		c.mapper.Reset()
+	}
+
+	lists, err := c.discovery.ServerPreferredResources()
+	if err != nil {
+		if !discovery.IsGroupDiscoveryFailedError(err) {
+			log.Errorf("error discovering API resources: %v", err)
+			return nil, fmt.Errorf("error discovering API resources: %w", err)
+		}
+		log.Warnf("Partial API discovery failure, continuing with available groups: %v", err)
+	}
+
+	lists = discovery.FilteredBy(discovery.SupportsAllVerbs{Verbs: []string{"list", "delete"}}, lists)
+
+	var resources []apiResource
+	for _, list := range lists {
+		gv, err := schema.ParseGroupVersion(list.GroupVersion)
+		if err != nil {
+			log.Warnf("Skipping invalid group version %s: %v", list.GroupVersion, err)
+			continue
+		}
+		for _, res := range list.APIResources {
+			resources = append(resources, apiResource{
+				gvr:        gv.WithResource(res.Name),
+				kind:       res.Kind,
+				namespaced: res.Namespaced,
+			})
+		}
+	}
+
+	log.Infof("Discovered %d listable resource types", len(resources))
+	c.resources = resources
+	c.resourcesFetchedAt = time.Now()
+	return resources, nil
+}
+
+func (c *Client) IsNamespaced(gvk schema.GroupVersionKind) (bool, error) {
+	mapping, err := c.restMapping(gvk)
+	if err != nil {
+		return false, fmt.Errorf("error getting REST mapping for %s: %w", gvk, err)
+	}
+	return mapping.Scope.Name() == meta.RESTScopeNameNamespace, nil
+}
@@ -10,14 +10,25 @@ import (
 	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/dynamic"
 )
 
+func (c *Client) restMapping(gvk schema.GroupVersionKind) (*meta.RESTMapping, error) {
+	mapping, err := c.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if meta.IsNoMatchError(err) {
+		// The kind may have been registered since discovery was cached, e.g. a CRD applied earlier in this sync.
+		c.mapper.Reset()
+		mapping, err = c.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	}
+	return mapping, err
+}
+
 func (c *Client) getResourceInterface(manifest manifest.Manifest) (dynamic.ResourceInterface, error) {
 	obj := manifest.Object
 	gvk := obj.GroupVersionKind()
 
-	mapping, err := c.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	mapping, err := c.restMapping(gvk)
 	if err != nil {
 		log.Errorf("error getting REST mapping for %s: %v", gvk, err)
 		return nil, fmt.Errorf("error getting REST mapping for %s: %w", gvk, err)
@@ -33,7 +44,7 @@ func (c *Client) getResourceInterface(manifest manifest.Manifest) (dynamic.Resou
 	return resourceInterface, nil
 }
 
-func (c *Client) Get(manifest manifest.Manifest) (*unstructured.Unstructured, error) {
+func (c *Client) Get(ctx context.Context, manifest manifest.Manifest) (*unstructured.Unstructured, error) {
 	resourceInterface, err := c.getResourceInterface(manifest)
 	if err != nil {
 		return nil, err
@@ -46,10 +57,10 @@ func (c *Client) Get(manifest manifest.Manifest) (*unstructured.Unstructured, er
 	}
 	log.WithFields(logFields).Info("Getting resource")
 
-	return resourceInterface.Get(context.TODO(), manifest.Name, metav1.GetOptions{})
+	return resourceInterface.Get(ctx, manifest.Name, metav1.GetOptions{})
 }
 
-func (c *Client) Delete(manifest manifest.Manifest) error {
+func (c *Client) Delete(ctx context.Context, manifest manifest.Manifest) error {
 	resourceInterface, err := c.getResourceInterface(manifest)
 	if err != nil {
 		return err
@@ -62,5 +73,5 @@ func (c *Client) Delete(manifest manifest.Manifest) error {
 	}
 	log.WithFields(logFields).Info("Deleting resource")
 
-	return resourceInterface.Delete(context.TODO(), manifest.Name, metav1.DeleteOptions{})
+	return resourceInterface.Delete(ctx, manifest.Name, metav1.DeleteOptions{})
 }
@@ -0,0 +1,269 @@
+package k8s
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/MyoMyatMin/gitops-controller/internal/config"
+	"github.com/MyoMyatMin/gitops-controller/internal/log"
+	"github.com/MyoMyatMin/gitops-controller/pkg/manifest"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	InventoryLabel        = "gitops-controller/inventory"
+	inventoryCommitKey    = "commit"
+	inventoryResourcesKey = "resources"
+	inventoryHistoryKey   = "history"
+	inventorySuspendedKey = "suspended"
+	inventoryStatusKey    = "status"
+
+	// A follower that receives a webhook stores a request the leader compares
+	// with the last one it handled.
+	inventorySyncRequestedKey = "sync_requested"
+	inventorySyncHandledKey   = "sync_handled"
+)
+
+// stateKeys are saved separately from the inventory proper and kept by
+// SaveInventory.
+var stateKeys = []string{inventorySuspendedKey, inventoryStatusKey, inventorySyncRequestedKey, inventorySyncHandledKey}
+
+type InventoryEntry struct {
+	Group     string `json:"group"`
+	Version   string `json:"version"`
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+}
+
+// SyncRecord is one entry in the per-repository history of synced commits.
+type SyncRecord struct {
+	Commit string    `json:"commit"`
+	Ref    string    `json:"ref"`
+	Status string    `json:"status"`
+	Time   time.Time `json:"time"`
+}
+
+// SyncStatus is what a repository's last sync left behind, saved so that every
+// replica reports the same status.
+type SyncStatus struct {
+	Commit     string    `json:"commit"`
+	Ref        string    `json:"ref"`
+	Trigger    string    `json:"trigger"`
+	Status     string    `json:"status"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	Errors     []string  `json:"errors,omitempty"`
+	// SyncedCommit and LastSuccess are those of the last successful sync.
+	SyncedCommit string    `json:"synced_commit,omitempty"`
+	LastSuccess  time.Time `json:"last_success,omitempty"`
+	Drift        bool      `json:"drift"`
+	DriftReasons []string  `json:"drift_reasons,omitempty"`
+}
+
+// Inventory is the state of a repository kept in its ConfigMap. Suspended,
+// Status and the sync request fields are written by their own Save methods;
+// SaveInventory keeps the stored values.
+type Inventory struct {
+	Commit    string
+	Resources []InventoryEntry
+	History   []SyncRecord
+
+	Suspended     bool
+	Status        *SyncStatus
+	SyncRequested string
+	SyncHandled   string
+}
+
+func NewInventoryEntry(obj *unstructured.Unstructured) InventoryEntry {
+	gvk := obj.GroupVersionKind()
+	return InventoryEntry{
+		Group:     gvk.Group,
+		Version:   gvk.Version,
+		Kind:      gvk.Kind,
+		Namespace: obj.GetNamespace(),
+		Name:      obj.GetName(),
+	}
+}
+
+func (e InventoryEntry) Manifest() manifest.Manifest {
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(schema.GroupVersionKind{Group: e.Group, Version: e.Version, Kind: e.Kind})
+	obj.SetNamespace(e.Namespace)
+	obj.SetName(e.Name)
+
+	return manifest.Manifest{
+		Kind:      e.Kind,
+		Name:      e.Name,
+		Namespace: e.Namespace,
+		Object:    obj,
+	}
+}
+
+func InventoryName(repository string) string {
+	return config.InventoryNamePrefix + repository
+}
+
+// GetInventory returns the stored inventory for repository, or nil if none has been recorded yet.
+func (c *Client) GetInventory(ctx context.Context, namespace, repository string) (*Inventory, error) {
+	name := InventoryName(repository)
+	cm, err := c.clientset.CoreV1().ConfigMaps(namespace).Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		log.Errorf("error getting inventory %s/%s: %v", namespace, name, err)
+		return nil, fmt.Errorf("error getting inventory %s/%s: %w", namespace, name, err)
+	}
+
+	inv := &Inventory{Commit: cm.Data[inventoryCommitKey]}
+	inv.Suspended, _ = strconv.ParseBool(cm.Data[inventorySuspendedKey])
+	inv.SyncRequested = cm.Data[inventorySyncRequestedKey]
+	inv.SyncHandled = cm.Data[inventorySyncHandledKey]
+	if data := cm.Data[inventoryResourcesKey]; data != "" {
+		if err := json.Unmarshal([]byte(data), &inv.Resources); err != nil {
+			log.Errorf("error decoding inventory %s/%s: %v", namespace, name, err)
+			return nil, fmt.Errorf("error decoding inventory %s/%s: %w", namespace, name, err)
+		}
+	}
+	if data := cm.Data[inventoryHistoryKey]; data != "" {
+		if err := json.Unmarshal([]byte(data), &inv.History); err != nil {
+			log.Errorf("error decoding history in inventory %s/%s: %v", namespace, name, err)
+			return nil, fmt.Errorf("error decoding history in inventory %s/%s: %w", namespace, name, err)
+		}
+	}
+	if data := cm.Data[inventoryStatusKey]; data != "" {
+		inv.Status = &SyncStatus{}
+		if err := json.Unmarshal([]byte(data), inv.Status); err != nil {
+			log.Errorf("error decoding status in inventory %s/%s: %v", namespace, name, err)
+			return nil, fmt.Errorf("error decoding status in inventory %s/%s: %w", namespace, name, err)
+		}
+	}
+
+	return inv, nil
+}
+
+func (c *Client) SaveInventory(ctx context.Context, namespace, repository string, inv *Inventory) error {
+	name := InventoryName(repository)
+
+	data, err := json.Marshal(inv.Resources)
+	if err != nil {
+		return fmt.Errorf("error encoding inventory %s/%s: %w", namespace, name, err)
+	}
+	history, err := json.Marshal(inv.History)
+	if err != nil {
+		return fmt.Errorf("error encoding history in inventory %s/%s: %w", namespace, name, err)
+	}
+
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    map[string]string{InventoryLabel: repository},
+		},
+		Data: map[string]string{
+			inventoryCommitKey:    inv.Commit,
+			inventoryResourcesKey: string(data),
+			inventoryHistoryKey:   string(history),
+		},
+	}
+
+	err = c.updateInventory(ctx, namespace, name, func(existing *corev1.ConfigMap) *corev1.ConfigMap {
+		for _, key := range stateKeys {
+			if value, ok := existing.Data[key]; ok {
+				cm.Data[key] = value
+			} else {
+				delete(cm.Data, key)
+			}
+		}
+		cm.ResourceVersion = existing.ResourceVersion
+		return cm
+	}, cm)
+	if err != nil {
+		log.Errorf("error saving inventory %s/%s: %v", namespace, name, err)
+		return fmt.Errorf("error saving inventory %s/%s: %w", namespace, name, err)
+	}
+
+	log.Infof("Saved inventory %s/%s with %d resources at commit %s", namespace, name, len(inv.Resources), inv.Commit)
+	return nil
+}
+
+// SaveSuspended records whether auto-sync of repository is suspended.
+func (c *Client) SaveSuspended(ctx context.Context, namespace, repository string, suspended bool) error {
+	return c.saveInventoryKey(ctx, namespace, repository, inventorySuspendedKey, strconv.FormatBool(suspended))
+}
+
+// SaveStatus records the outcome of repository's last sync.
+func (c *Client) SaveStatus(ctx context.Context, namespace, repository string, status *SyncStatus) error {
+	data, err := json.Marshal(status)
+	if err != nil {
+		return fmt.Errorf("error encoding status in inventory %s/%s: %w", namespace, InventoryName(repository), err)
+	}
+	return c.saveInventoryKey(ctx, namespace, repository, inventoryStatusKey, string(data))
+}
+
+// RequestSync asks the leader to sync repository. Requests are identified by
+// id, e.g. the time they were made.
+func (c *Client) RequestSync(ctx context.Context, namespace, repository, id string) error {
+	return c.saveInventoryKey(ctx, namespace, repository, inventorySyncRequestedKey, id)
+}
+
+// SaveSyncHandled records that the sync request id was handled.
+func (c *Client) SaveSyncHandled(ctx context.Context, namespace, repository, id string) error {
+	return c.saveInventoryKey(ctx, namespace, repository, inventorySyncHandledKey, id)
+}
+
+// saveInventoryKey sets key in repository's inventory, leaving the rest of it
+// untouched.
+func (c *Client) saveInventoryKey(ctx context.Context, namespace, repository, key, value string) error {
+	name := InventoryName(repository)
+
+	created := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    map[string]string{InventoryLabel: repository},
+		},
+		Data: map[string]string{key: value},
+	}
+	err := c.updateInventory(ctx, namespace, name, func(existing *corev1.ConfigMap) *corev1.ConfigMap {
+		cm := existing.DeepCopy()
+		if cm.Data == nil {
+			cm.Data = map[string]string{}
+		}
+		cm.Data[key] = value
+		return cm
+	}, created)
+	if err != nil {
+		log.Errorf("error saving %s in inventory %s/%s: %v", key, namespace, name, err)
+		return fmt.Errorf("error saving %s in inventory %s/%s: %w", key, namespace, name, err)
+	}
+	return nil
+}
+
+// updateInventory creates the inventory ConfigMap as created, or replaces it
+// with what update returns for the stored one, retrying on conflicts.
+func (c *Client) updateInventory(ctx context.Context, namespace, name string, update func(*corev1.ConfigMap) *corev1.ConfigMap, created *corev1.ConfigMap) error {
+	configMaps := c.clientset.CoreV1().ConfigMaps(namespace)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		existing, err := configMaps.Get(ctx, name, metav1.GetOptions{})
+		switch {
+		case apierrors.IsNotFound(err):
+			_, err = configMaps.Create(ctx, created, metav1.CreateOptions{FieldManager: FieldManager})
+			if apierrors.IsAlreadyExists(err) {
+				return apierrors.NewConflict(corev1.Resource("configmaps"), name, err)
+			}
+		case err == nil:
+			_, err = configMaps.Update(ctx, update(existing), metav1.UpdateOptions{FieldManager: FieldManager})
+		}
+		return err
+	})
+}
@@ -7,35 +7,50 @@ import (
 	"github.com/MyoMyatMin/gitops-controller/internal/log"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
-	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
 )
 
-func (c *Client) ListManagedResources(namespace string) ([]unstructured.Unstructured, error) {
+// ListManagedResources returns the resources in namespace carrying the managed-by
+// label. When repository is non-empty only objects owned by that repository are returned.
+func (c *Client) ListManagedResources(ctx context.Context, namespace, repository string) ([]unstructured.Unstructured, error) {
 	var managedResources []unstructured.Unstructured
 
-	gvrs := []schema.GroupVersionResource{
-		{Group: "apps", Version: "v1", Resource: "deployments"},
-		{Group: "apps", Version: "v1", Resource: "statefulsets"},
-		{Group: "", Version: "v1", Resource: "services"},
-		{Group: "", Version: "v1", Resource: "configmaps"},
-		{Group: "", Version: "v1", Resource: "secrets"},
-		{Group: "networking.k8s.io", Version: "v1", Resource: "ingresses"},
+	resources, err := c.listableResources()
+	if err != nil {
+		return nil, err
 	}
 
 	labelSelector := fmt.Sprintf("%s=%s", ManagedByLabel, FieldManager)
+	if repository != "" {
+		labelSelector = fmt.Sprintf("%s,%s=%s", labelSelector, RepositoryLabel, repository)
+	}
 
-	for _, gvr := range gvrs {
-		list, err := c.dynamic.Resource(gvr).Namespace(namespace).List(context.TODO(), metav1.ListOptions{
+	for _, res := range resources {
+		var resourceInterface dynamic.ResourceInterface
+		if res.namespaced {
+			resourceInterface = c.dynamic.Resource(res.gvr).Namespace(namespace)
+		} else if c.includeClusterScoped {
+			resourceInterface = c.dynamic.Resource(res.gvr)
+		} else {
+			continue
+		}
+
+		list, err := resourceInterface.List(ctx, metav1.ListOptions{
 			LabelSelector: labelSelector,
 		})
 
 		if err != nil {
-			log.Warnf("Could not list %s: %v", gvr.Resource, err)
+			log.Warnf("Could not list %s: %v", res.gvr.String(), err)
 			continue
 
 		}
 
-		managedResources = append(managedResources, list.Items...)
+		for _, item := range list.Items {
+			if item.GetKind() == "" {
+				item.SetGroupVersionKind(res.gvr.GroupVersion().WithKind(res.kind))
+			}
+			managedResources = append(managedResources, item)
+		}
 	}
 
 	log.Infof("Found %d managed resources in namespace %s", len(managedResources), namespace)
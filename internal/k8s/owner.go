@@ -0,0 +1,30 @@
+package k8s
+
+import (
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const (
+	RepositoryLabel = "gitops-controller/repository"
+	PathAnnotation  = "gitops-controller/path"
+)
+
+func SetOwner(obj *unstructured.Unstructured, repository, path string) {
+	labels := obj.GetLabels()
+	if labels == nil {
+		labels = make(map[string]string)
+	}
+	labels[RepositoryLabel] = repository
+	obj.SetLabels(labels)
+
+	annotations := obj.GetAnnotations()
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	annotations[PathAnnotation] = path
+	obj.SetAnnotations(annotations)
+}
+
+func OwnerOf(obj *unstructured.Unstructured) string {
+	return obj.GetLabels()[RepositoryLabel]
+}
@@ -0,0 +1,128 @@
+package leader
+
+import (
+	"context"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/MyoMyatMin/gitops-controller/internal/config"
+	"github.com/MyoMyatMin/gitops-controller/internal/k8s"
+	"github.com/MyoMyatMin/gitops-controller/internal/log"
+	"github.com/MyoMyatMin/gitops-controller/internal/metrics"
+	"k8s.io/client-go/tools/leaderelection"
+)
+
+const serviceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// Leadership tracks whether this replica may sync.
+type Leadership struct {
+	mu  sync.RWMutex
+	ctx context.Context
+
+	// leadMu keeps a new term from starting before the last one has wound down.
+	leadMu sync.Mutex
+}
+
+// Always returns a Leadership that leads for the lifetime of ctx, for when
+// leader election is disabled.
+func Always(ctx context.Context) *Leadership {
+	metrics.IsLeader.Set(1)
+	return &Leadership{ctx: ctx}
+}
+
+func New() *Leadership {
+	metrics.IsLeader.Set(0)
+	return &Leadership{}
+}
+
+// Context returns a context that is cancelled when leadership is lost, and
+// false while this replica is not the leader.
+func (l *Leadership) Context() (context.Context, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if l.ctx == nil || l.ctx.Err() != nil {
+		return nil, false
+	}
+	return l.ctx, true
+}
+
+func (l *Leadership) IsLeader() bool {
+	_, ok := l.Context()
+	return ok
+}
+
+func (l *Leadership) set(ctx context.Context) {
+	l.mu.Lock()
+	l.ctx = ctx
+	l.mu.Unlock()
+}
+
+// Run campaigns for the Lease until ctx is cancelled. Each time leadership is
+// acquired, lead is called with a context that is cancelled when it is lost
+// and must return once that happens; the replica then rejoins the election.
+// The Lease is released on shutdown so another replica can take over at once.
+func (l *Leadership) Run(ctx context.Context, client *k8s.Client, cfg config.LeaderElectionConfig, lead func(context.Context)) {
+	identity := cfg.Identity
+	if identity == "" {
+		identity, _ = os.Hostname()
+	}
+	namespace := cfg.LeaseNamespace
+	if namespace == "" {
+		namespace = podNamespace()
+	}
+
+	log.Infof("Starting leader election for lease %s/%s as %s", namespace, cfg.LeaseName, identity)
+
+	electionCfg := leaderelection.LeaderElectionConfig{
+		Lock:            client.NewLeaseLock(namespace, cfg.LeaseName, identity),
+		LeaseDuration:   cfg.LeaseDuration,
+		RenewDeadline:   cfg.RenewDeadline,
+		RetryPeriod:     cfg.RetryPeriod,
+		ReleaseOnCancel: true,
+		Name:            cfg.LeaseName,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(leaderCtx context.Context) {
+				l.leadMu.Lock()
+				defer l.leadMu.Unlock()
+
+				log.Infof("Acquired leadership as %s", identity)
+				l.set(leaderCtx)
+				metrics.IsLeader.Set(1)
+				lead(leaderCtx)
+			},
+			OnStoppedLeading: func() {
+				l.set(nil)
+				metrics.IsLeader.Set(0)
+				log.Warnf("Lost leadership as %s", identity)
+			},
+			OnNewLeader: func(current string) {
+				if current != identity {
+					log.Infof("Following leader %s", current)
+				}
+			},
+		},
+	}
+
+	for ctx.Err() == nil {
+		leaderelection.RunOrDie(ctx, electionCfg)
+	}
+
+	// Wait for the last term to wind down.
+	l.leadMu.Lock()
+	l.leadMu.Unlock()
+}
+
+// podNamespace is the namespace the controller runs in, falling back to
+// "default" outside a cluster.
+func podNamespace() string {
+	if ns := os.Getenv("POD_NAMESPACE"); ns != "" {
+		return ns
+	}
+	if data, err := os.ReadFile(serviceAccountNamespaceFile); err == nil {
+		if ns := strings.TrimSpace(string(data)); ns != "" {
+			return ns
+		}
+	}
+	return "default"
+}
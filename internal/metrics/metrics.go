@@ -40,6 +40,36 @@ var (
 			Name: "gitops_drift_detected",
 			Help: "Indicates if configuration drift is detected.",
 		})
+
+	SignatureVerificationFailures = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gitops_signature_verification_failures_total",
+			Help: "Total number of commits rejected because their signature was missing or untrusted",
+		},
+		[]string{"repository"},
+	)
+
+	WebhookRejected = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gitops_webhook_rejected_total",
+			Help: "Total number of webhook deliveries rejected, partitioned by provider and reason",
+		},
+		[]string{"provider", "reason"},
+	)
+
+	RepositorySuspended = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gitops_repository_suspended",
+			Help: "Whether auto-sync is suspended for a repository (1) or not (0)",
+		},
+		[]string{"repository"},
+	)
+
+	IsLeader = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "gitops_leader",
+			Help: "Whether this replica is the leader and runs syncs (1) or not (0)",
+		})
 )
 
 func Register() {
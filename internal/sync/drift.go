@@ -14,12 +14,12 @@ func DetectDrift(gitManifests []manifest.Manifest, clusterResources []unstructur
 
 	clusterMap := make(map[string]unstructured.Unstructured)
 	for _, res := range clusterResources {
-		key := fmt.Sprintf("%s/%s/%s", res.GetKind(), res.GetNamespace(), res.GetName())
+		key := resourceKey(res.GetKind(), res.GetNamespace(), res.GetName())
 		clusterMap[key] = res
 	}
 
 	for _, gitRes := range gitManifests {
-		key := fmt.Sprintf("%s/%s/%s", gitRes.Kind, gitRes.Object.GetNamespace(), gitRes.Name)
+		key := resourceKey(gitRes.Kind, gitRes.Object.GetNamespace(), gitRes.Name)
 		clusterRes, exists := clusterMap[key]
 		if !exists {
 			hasDrift = true
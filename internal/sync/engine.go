@@ -1,10 +1,18 @@
 package sync
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io/fs"
+	"path"
 	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/MyoMyatMin/gitops-controller/internal/config"
 	"github.com/MyoMyatMin/gitops-controller/internal/git"
 	"github.com/MyoMyatMin/gitops-controller/internal/k8s"
 	"github.com/MyoMyatMin/gitops-controller/internal/log"
@@ -12,22 +20,111 @@ import (
 	"github.com/MyoMyatMin/gitops-controller/pkg/manifest"
 	"github.com/cenkalti/backoff/v4"
 	"github.com/prometheus/client_golang/prometheus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 )
 
 type Engine struct {
 	gitRepo   *git.Repository
 	k8sClient *k8s.Client
+	name      string
 	namespace string
 	repoPath  string
+	prune     bool
+
+	syncTimeout   time.Duration
+	healthTimeout time.Duration
+	waitForHealth bool
+
+	helm config.HelmConfig
+
+	watchPaths    []string
+	lastSyncedSHA string
+	cloned        atomic.Bool
+	cloning       atomic.Bool
+
+	// suspended mirrors the flag saved in the inventory; suspendMu
+	// serializes changes to it and stateLoaded is set once it was read.
+	suspended   atomic.Bool
+	suspendMu   sync.Mutex
+	stateLoaded atomic.Bool
+
+	// syncMu is held for the duration of any sync; queueMu guards pending
+	// and runCtx, which bounds queued syncs.
+	syncMu  sync.Mutex
+	queueMu sync.Mutex
+	pending *syncRequest
+	runCtx  context.Context
+	// inflight counts queued syncs that have not finished.
+	inflight sync.WaitGroup
+
+	// statusMu guards the fields below, which are read by status reporting.
+	statusMu     sync.RWMutex
+	recentSyncs  []*SyncResult
+	lastSuccess  time.Time
+	syncedSHA    string
+	drift        bool
+	driftReasons []string
+	// attempted is set once a sync other than a dry run has been recorded,
+	// at recordedAt.
+	attempted  bool
+	recordedAt time.Time
+	// saved is the status last read from the inventory, which is newer than
+	// the fields above when another replica synced since.
+	saved *k8s.SyncStatus
+	// busySince is when the operation holding syncMu started.
+	busySince time.Time
+	// cloneErr is the error of the last failed clone attempt.
+	cloneErr error
+}
+
+const (
+	defaultSyncTimeout   = 10 * time.Minute
+	inventorySaveTimeout = 30 * time.Second
+
+	// maxSyncHistory bounds the number of syncs recorded in a repository's inventory.
+	maxSyncHistory = 20
+)
+
+const (
+	SyncStatusSuccess  = "success"
+	SyncStatusDegraded = "degraded"
+	SyncStatusFailure  = "failure"
+)
+
+// SyncOptions adjusts a single sync.
+type SyncOptions struct {
+	// Revision syncs this commit instead of the tracked ref.
+	Revision string
+	// Prune overrides the repository's prune setting when set.
+	Prune *bool
+	// DryRun applies with server-side dry-run and changes nothing.
+	DryRun bool
+}
+
+type applyOptions struct {
+	skipUnchanged bool
+	prune         bool
+	dryRun        bool
 }
 
 type SyncResult struct {
-	CommitSHA string
-	Created   []string
-	Updated   []string
-	Deleted   []string
-	Errors    []error
+	CommitSHA    string
+	Ref          string
+	Trigger      Trigger
+	DryRun       bool
+	Status       string
+	StartedAt    time.Time
+	FinishedAt   time.Time
+	Created      []string
+	Updated      []string
+	Deleted      []string
+	Orphaned     []string
+	Skipped      bool
+	Health       map[string]ResourceHealth
+	Drift        bool
+	DriftReasons []string
+	Errors       []error
 }
 
 type RetryConfig struct {
@@ -36,85 +133,491 @@ type RetryConfig struct {
 	MaxDelay     time.Duration
 }
 
-func NewEngine(repo *git.Repository, client *k8s.Client, ns, path string) *Engine {
-	return &Engine{
-		gitRepo:   repo,
-		k8sClient: client,
-		namespace: ns,
-		repoPath:  path,
+// ErrNoRollbackTarget is returned by Rollback when no earlier commit was
+// synced successfully.
+var ErrNoRollbackTarget = errors.New("no previous successful sync to roll back to")
+
+// NewEngine creates the engine for a repository and restores its suspended
+// state from the inventory. Failing to read it is logged; the poller retries
+// before its first sync.
+func NewEngine(ctx context.Context, repo *git.Repository, client *k8s.Client, cfg config.RepositoryConfig) *Engine {
+	syncTimeout := cfg.SyncTimeout
+	if syncTimeout <= 0 {
+		syncTimeout = defaultSyncTimeout
+	}
+	healthTimeout := cfg.HealthTimeout
+	if healthTimeout <= 0 {
+		healthTimeout = defaultHealthTimeout
+	}
+	metrics.RepositorySuspended.WithLabelValues(cfg.Name).Set(0)
+
+	e := &Engine{
+		gitRepo:       repo,
+		k8sClient:     client,
+		name:          cfg.Name,
+		namespace:     cfg.Namespace,
+		repoPath:      repoRelativePath(cfg.Path),
+		prune:         cfg.Prune,
+		syncTimeout:   syncTimeout,
+		healthTimeout: healthTimeout,
+		waitForHealth: cfg.WaitForHealth,
+		helm:          cfg.Helm,
+		runCtx:        context.Background(),
+	}
+	for _, p := range append([]string{cfg.Path}, cfg.DependencyPaths...) {
+		e.watchPaths = append(e.watchPaths, repoRelativePath(p))
+	}
+	e.cloned.Store(repo.Cloned())
+	if err := e.LoadState(ctx); err != nil {
+		log.Errorf("Error loading state of %s: %v", e.name, err)
+	}
+	return e
+}
+
+func (e *Engine) Name() string {
+	return e.name
+}
+
+// SetContext sets the context queued syncs run under, typically the context
+// of the current leadership term. A queued sync is shared by every trigger
+// waiting on it, so it is never bound to the context of one of them.
+func (e *Engine) SetContext(ctx context.Context) {
+	e.queueMu.Lock()
+	defer e.queueMu.Unlock()
+	e.runCtx = ctx
+}
+
+// Wait blocks until every queued sync has finished, including saving its
+// inventory.
+func (e *Engine) Wait() {
+	e.inflight.Wait()
+}
+
+// EnsureCloned clones the repository unless that has already succeeded. The
+// outcome of the last attempt is reported by Status.
+func (e *Engine) EnsureCloned(ctx context.Context) error {
+	if e.cloned.Load() {
+		return nil
+	}
+
+	e.lockSync()
+	defer e.unlockSync()
+	if e.cloned.Load() {
+		return nil
+	}
+
+	e.cloning.Store(true)
+	defer e.cloning.Store(false)
+
+	ctx, cancel := context.WithTimeout(ctx, e.syncTimeout)
+	defer cancel()
+
+	err := e.gitRepo.Clone(ctx)
+	e.statusMu.Lock()
+	e.cloneErr = err
+	e.statusMu.Unlock()
+	if err != nil {
+		return err
+	}
+	e.cloned.Store(true)
+	log.Infof("Repository %s cloned", e.name)
+	return nil
+}
+
+// MatchesPush reports whether a push of ref to any of cloneURLs affects this
+// engine's repository.
+func (e *Engine) MatchesPush(cloneURLs []string, ref string) bool {
+	return e.gitRepo.MatchesPush(cloneURLs, ref)
+}
+
+// Suspended reports whether auto-sync from the poller and webhooks is paused.
+func (e *Engine) Suspended() bool {
+	return e.suspended.Load()
+}
+
+// Suspend pauses auto-sync. The flag is saved in the inventory so that it
+// survives restarts and leader changes.
+func (e *Engine) Suspend(ctx context.Context) error {
+	return e.saveSuspended(ctx, true)
+}
+
+func (e *Engine) Resume(ctx context.Context) error {
+	return e.saveSuspended(ctx, false)
+}
+
+func (e *Engine) saveSuspended(ctx context.Context, suspended bool) error {
+	e.suspendMu.Lock()
+	defer e.suspendMu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, inventorySaveTimeout)
+	defer cancel()
+	if err := e.k8sClient.SaveSuspended(ctx, e.namespace, e.name, suspended); err != nil {
+		return err
 	}
+	e.setSuspended(suspended)
+	e.stateLoaded.Store(true)
+	return nil
 }
 
-func (e *Engine) Sync() (*SyncResult, error) {
+// LoadState reads the suspended flag and sync status saved in the inventory.
+func (e *Engine) LoadState(ctx context.Context) error {
+	e.suspendMu.Lock()
+	defer e.suspendMu.Unlock()
 
-	log.Info("--- Starting Sync ---")
+	ctx, cancel := context.WithTimeout(ctx, inventorySaveTimeout)
+	defer cancel()
+	inventory, err := e.k8sClient.GetInventory(ctx, e.namespace, e.name)
+	if err != nil {
+		return err
+	}
+	e.setSuspended(inventory != nil && inventory.Suspended)
+	if inventory != nil && inventory.Status != nil {
+		e.statusMu.Lock()
+		e.saved = inventory.Status
+		e.statusMu.Unlock()
+	}
+	e.stateLoaded.Store(true)
+	return nil
+}
+
+// RequestSync asks the leader to sync, for a replica that is not the leader.
+func (e *Engine) RequestSync(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, inventorySaveTimeout)
+	defer cancel()
+	return e.k8sClient.RequestSync(ctx, e.namespace, e.name, time.Now().UTC().Format(time.RFC3339Nano))
+}
+
+// SyncRequested syncs if a follower requested it since the last request was
+// handled. It reports whether a request was pending.
+func (e *Engine) SyncRequested(ctx context.Context) (bool, error) {
+	readCtx, cancel := context.WithTimeout(ctx, inventorySaveTimeout)
+	defer cancel()
+	inventory, err := e.k8sClient.GetInventory(readCtx, e.namespace, e.name)
+	if err != nil {
+		return false, err
+	}
+	if inventory == nil || inventory.SyncRequested == "" || inventory.SyncRequested == inventory.SyncHandled {
+		return false, nil
+	}
+
+	log.Infof("Sync of %s requested by a follower at %s", e.name, inventory.SyncRequested)
+	if _, err := e.Sync(ctx, TriggerWebhook); err != nil && !errors.Is(err, ErrSuspended) {
+		return true, err
+	}
+
+	saveCtx, cancelSave := context.WithTimeout(context.WithoutCancel(ctx), inventorySaveTimeout)
+	defer cancelSave()
+	return true, e.k8sClient.SaveSyncHandled(saveCtx, e.namespace, e.name, inventory.SyncRequested)
+}
+
+// EnsureStateLoaded loads the saved state if that failed when the engine was
+// created.
+func (e *Engine) EnsureStateLoaded(ctx context.Context) error {
+	if e.stateLoaded.Load() {
+		return nil
+	}
+	return e.LoadState(ctx)
+}
+
+func (e *Engine) setSuspended(suspended bool) {
+	if e.suspended.Swap(suspended) == suspended {
+		return
+	}
+	if suspended {
+		metrics.RepositorySuspended.WithLabelValues(e.name).Set(1)
+		log.Warnf("Auto-sync suspended for %s", e.name)
+	} else {
+		metrics.RepositorySuspended.WithLabelValues(e.name).Set(0)
+		log.Infof("Auto-sync resumed for %s", e.name)
+	}
+}
+
+// pullAndSync pulls the configured ref and applies it. The sync is abandoned
+// when ctx is cancelled or the repository's sync timeout expires.
+func (e *Engine) pullAndSync(ctx context.Context, opts applyOptions) (*SyncResult, error) {
+
+	log.Infof("--- Starting Sync for %s ---", e.name)
 
 	syncTimer := prometheus.NewTimer(metrics.SyncDuration)
 	defer syncTimer.ObserveDuration()
 
-	result := &SyncResult{}
+	ctx, cancel := context.WithTimeout(ctx, e.syncTimeout)
+	defer cancel()
 
-	if err := e.gitRepo.Pull(); err != nil {
-		metrics.SyncTotal.WithLabelValues("failure").Inc()
+	if err := e.gitRepo.Pull(ctx); err != nil {
+		if errors.Is(err, git.ErrUnverifiedCommit) {
+			metrics.SignatureVerificationFailures.WithLabelValues(e.name).Inc()
+		}
+		metrics.SyncTotal.WithLabelValues(SyncStatusFailure).Inc()
 		log.Errorf("error pulling git repo: %v", err)
 		return nil, fmt.Errorf("error pulling git repo: %w", err)
 	}
+
+	return e.syncCheckout(ctx, opts)
+}
+
+// SyncToCommit checks out and applies a specific commit from history. Once the
+// commit is checked out and verified, auto-sync is suspended so the next poll
+// does not move the repository back to the head of its ref; call Resume to
+// follow the ref again.
+func (e *Engine) SyncToCommit(ctx context.Context, sha string) (*SyncResult, error) {
+	e.lockSync()
+	defer e.unlockSync()
+
+	startedAt := time.Now()
+	result, err := e.syncToCommit(ctx, sha, e.prune)
+	e.recordSync(TriggerManual, startedAt, result, err)
+	return result, err
+}
+
+// SyncWithOptions runs a sync that deviates from the tracked ref or the
+// repository settings. Without options it is the same as Sync.
+func (e *Engine) SyncWithOptions(ctx context.Context, trigger Trigger, opts SyncOptions) (*SyncResult, error) {
+	if opts == (SyncOptions{}) {
+		return e.Sync(ctx, trigger)
+	}
+
+	e.lockSync()
+	defer e.unlockSync()
+
+	prune := e.prune
+	if opts.Prune != nil {
+		prune = *opts.Prune
+	}
+
+	startedAt := time.Now()
+	var result *SyncResult
+	var err error
+	switch {
+	case opts.DryRun:
+		result, err = e.dryRun(ctx, opts.Revision, prune)
+	case opts.Revision != "":
+		result, err = e.syncToCommit(ctx, opts.Revision, prune)
+	default:
+		result, err = e.pullAndSync(ctx, applyOptions{prune: prune})
+	}
+	e.recordSync(trigger, startedAt, result, err)
+	return result, err
+}
+
+// dryRun previews a sync of revision, or of the tracked ref when empty, using
+// server-side dry-run applies. The worktree, inventory and history are left
+// untouched and nothing is pruned.
+func (e *Engine) dryRun(ctx context.Context, revision string, prune bool) (*SyncResult, error) {
+	log.Infof("--- Starting Dry-Run Sync for %s ---", e.name)
+
+	ctx, cancel := context.WithTimeout(ctx, e.syncTimeout)
+	defer cancel()
+
+	commitSHA, ref, err := e.gitRepo.Resolve(ctx, revision)
+	if err != nil {
+		log.Errorf("error resolving revision: %v", err)
+		return nil, fmt.Errorf("error resolving revision: %w", err)
+	}
+	repoFS, err := e.gitRepo.CommitFS(commitSHA)
+	if err != nil {
+		log.Errorf("error reading commit %s: %v", commitSHA, err)
+		return nil, fmt.Errorf("error reading commit %s: %w", commitSHA, err)
+	}
+
+	return e.syncCommit(ctx, commitSHA, ref, repoFS, applyOptions{prune: prune, dryRun: true})
+}
+
+func (e *Engine) syncToCommit(ctx context.Context, sha string, prune bool) (*SyncResult, error) {
+	log.Infof("--- Starting Sync for %s at commit %s ---", e.name, sha)
+
+	syncTimer := prometheus.NewTimer(metrics.SyncDuration)
+	defer syncTimer.ObserveDuration()
+
+	ctx, cancel := context.WithTimeout(ctx, e.syncTimeout)
+	defer cancel()
+
+	// Suspending only after the checkout leaves auto-sync running when the
+	// revision is unknown or unverified. syncMu keeps polls out meanwhile.
+	if err := e.gitRepo.CheckoutCommit(ctx, sha); err != nil {
+		if errors.Is(err, git.ErrUnverifiedCommit) {
+			metrics.SignatureVerificationFailures.WithLabelValues(e.name).Inc()
+		}
+		metrics.SyncTotal.WithLabelValues(SyncStatusFailure).Inc()
+		log.Errorf("error checking out commit %s: %v", sha, err)
+		return nil, fmt.Errorf("error checking out commit %s: %w", sha, err)
+	}
+
+	if err := e.Suspend(ctx); err != nil {
+		metrics.SyncTotal.WithLabelValues(SyncStatusFailure).Inc()
+		return nil, fmt.Errorf("error suspending auto-sync of %s: %w", e.name, err)
+	}
+
+	return e.syncCheckout(ctx, applyOptions{prune: prune})
+}
+
+// Rollback re-applies the most recent successful commit before the one
+// currently checked out, leaving auto-sync suspended.
+func (e *Engine) Rollback(ctx context.Context) (*SyncResult, error) {
+	e.lockSync()
+	defer e.unlockSync()
+
+	history, err := e.History(ctx)
+	if err != nil {
+		return nil, err
+	}
+	current, err := e.gitRepo.GetLatestCommit()
+	if err != nil {
+		return nil, fmt.Errorf("error getting commit SHA: %w", err)
+	}
+
+	for i := len(history) - 1; i >= 0; i-- {
+		record := history[i]
+		if record.Status == SyncStatusSuccess && record.Commit != current {
+			log.Infof("Rolling back %s from %s to %s (synced %s)", e.name, current, record.Commit, record.Time.Format(time.RFC3339))
+			startedAt := time.Now()
+			result, err := e.syncToCommit(ctx, record.Commit, e.prune)
+			e.recordSync(TriggerManual, startedAt, result, err)
+			return result, err
+		}
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrNoRollbackTarget, e.name)
+}
+
+// History returns the recorded syncs of this repository, oldest first.
+func (e *Engine) History(ctx context.Context) ([]k8s.SyncRecord, error) {
+	inventory, err := e.k8sClient.GetInventory(ctx, e.namespace, e.name)
+	if err != nil {
+		return nil, err
+	}
+	if inventory == nil {
+		return nil, nil
+	}
+	return inventory.History, nil
+}
+
+// syncCheckout applies the commit currently checked out in the worktree.
+func (e *Engine) syncCheckout(ctx context.Context, opts applyOptions) (*SyncResult, error) {
 	commitSHA, err := e.gitRepo.GetLatestCommit()
 	if err != nil {
-		metrics.SyncTotal.WithLabelValues("failure").Inc()
+		metrics.SyncTotal.WithLabelValues(SyncStatusFailure).Inc()
 		log.Errorf("error getting commit SHA: %v", err)
 		return nil, fmt.Errorf("error getting commit SHA: %w", err)
 	}
-	result.CommitSHA = commitSHA
-	log.Infof("Syncing to commit: %s", commitSHA)
 
-	manifestDir := filepath.Join(e.gitRepo.LocalPath, e.repoPath)
-	gitManifests, err := ParseManifests(manifestDir)
+	repoFS, err := e.gitRepo.FS()
 	if err != nil {
-		metrics.SyncTotal.WithLabelValues("failure").Inc()
+		metrics.SyncTotal.WithLabelValues(SyncStatusFailure).Inc()
+		log.Errorf("error opening repository worktree: %v", err)
+		return nil, fmt.Errorf("error opening repository worktree: %w", err)
+	}
+
+	return e.syncCommit(ctx, commitSHA, e.gitRepo.ResolvedRef(), repoFS, opts)
+}
+
+// syncCommit applies the manifests of commitSHA read from repoFS. With
+// skipUnchanged, commits that do not touch the watched paths are not applied.
+func (e *Engine) syncCommit(ctx context.Context, commitSHA, ref string, repoFS fs.FS, opts applyOptions) (*SyncResult, error) {
+	result := &SyncResult{CommitSHA: commitSHA, Ref: ref, DryRun: opts.dryRun, Health: make(map[string]ResourceHealth)}
+	log.Infof("Syncing to commit: %s (%s)", commitSHA, result.Ref)
+
+	if opts.skipUnchanged && e.lastSyncedSHA != "" && e.lastSyncedSHA != commitSHA && !e.watchedPathsChanged(e.lastSyncedSHA, commitSHA) {
+		log.Infof("No changes under %v since %s, skipping apply", e.watchPaths, e.lastSyncedSHA)
+		e.lastSyncedSHA = commitSHA
+		result.Skipped = true
+		result.Status = SyncStatusSuccess
+		metrics.SyncTotal.WithLabelValues(result.Status).Inc()
+		metrics.LastSyncTimestamp.SetToCurrentTime()
+		return result, nil
+	}
+
+	manifestDir := e.repoPath
+	gitManifests, err := e.renderManifests(repoFS, manifestDir)
+	if err != nil {
+		metrics.SyncTotal.WithLabelValues(SyncStatusFailure).Inc()
 		log.Errorf("error parsing manifests: %v", err)
 		return nil, fmt.Errorf("error parsing manifests: %w", err)
 	}
+	for i := range gitManifests {
+		e.scopeManifest(&gitManifests[i])
+	}
 
-	clusterResources, err := e.k8sClient.ListManagedResources(e.namespace)
+	inventory, err := e.loadInventory(ctx)
 	if err != nil {
-		metrics.SyncTotal.WithLabelValues("failure").Inc()
-		log.Errorf("error listing managed resources: %v", err)
-		return nil, fmt.Errorf("error listing managed resources: %w", err)
+		metrics.SyncTotal.WithLabelValues(SyncStatusFailure).Inc()
+		log.Errorf("error loading inventory: %v", err)
+		return nil, fmt.Errorf("error loading inventory: %w", err)
 	}
 
-	toApply, toDelete := e.diff(gitManifests, clusterResources)
+	var declared []manifest.Manifest
+	var clusterResources []unstructured.Unstructured
+	existing := make(map[string]bool)
+	for _, m := range gitManifests {
+		key := resourceKey(m.Kind, m.Namespace, m.Name)
+		live, err := e.k8sClient.Get(ctx, m)
+		if err != nil {
+			if !apierrors.IsNotFound(err) {
+				log.Warnf("Could not get live state of %s: %v", key, err)
+			}
+			declared = append(declared, m)
+			continue
+		}
+
+		if owner := k8s.OwnerOf(live); owner != "" && owner != e.name {
+			err := fmt.Errorf("ownership conflict: %s is declared by repository %q but owned by repository %q", key, e.name, owner)
+			log.Error(err)
+			result.Errors = append(result.Errors, err)
+			continue
+		}
+
+		existing[key] = true
+		clusterResources = append(clusterResources, *live)
+		declared = append(declared, m)
+	}
+
+	toApply, toPrune := e.diff(declared, inventory)
+
+	nextInventory := &k8s.Inventory{Commit: commitSHA}
 
 	log.Infof("--- Applying %d resources ---", len(toApply))
+	applied := e.applyWaves(ctx, groupWaves(toApply), existing, result, opts.dryRun)
+
+	// Recorded after applying, as custom resources are re-scoped once their
+	// CRDs are established.
 	for _, m := range toApply {
-		m.Object.SetNamespace(e.namespace)
-		m.Namespace = e.namespace
+		nextInventory.Resources = append(nextInventory.Resources, k8s.NewInventoryEntry(m.Object))
+	}
 
-		if err := e.k8sClient.Apply(m, false); err != nil {
-			result.Errors = append(result.Errors, err)
-		} else {
-			result.Updated = append(result.Updated, m.Name)
-			metrics.ResourceManaged.WithLabelValues("applied", m.Kind).Inc()
-		}
+	if applied {
+		retained := e.pruneResources(ctx, toPrune, result, opts)
+		nextInventory.Resources = append(nextInventory.Resources, retained...)
+	} else {
+		log.Warnf("Skipping prune of %d resources because not all sync waves were applied", len(toPrune))
+		nextInventory.Resources = append(nextInventory.Resources, toPrune...)
 	}
 
-	log.Infof("--- Pruning %d resources ---", len(toDelete))
-	for _, res := range toDelete {
-		m := manifest.Manifest{
-			Object:    &res,
-			Kind:      res.GetKind(),
-			Name:      res.GetName(),
-			Namespace: res.GetNamespace(),
-		}
-		if err := e.k8sClient.Delete(m); err != nil {
-			result.Errors = append(result.Errors, err)
-		} else {
-			result.Deleted = append(result.Deleted, m.Name)
-			metrics.ResourceManaged.WithLabelValues("deleted", m.Kind).Inc()
-		}
+	if opts.dryRun {
+		result.Drift, result.DriftReasons = DetectDrift(declared, clusterResources)
+		result.Status = result.status()
+		log.Infof("--- Dry-Run Sync Complete: %s ---", result.Status)
+		return result, nil
+	}
+
+	result.Status = result.status()
+	nextInventory.History = appendHistory(inventory.History, k8s.SyncRecord{
+		Commit: commitSHA,
+		Ref:    result.Ref,
+		Status: result.Status,
+		Time:   time.Now().UTC(),
+	})
+
+	// Record what was applied even if the sync was cancelled part way through,
+	// so that those resources can still be pruned later.
+	saveCtx, cancelSave := context.WithTimeout(context.WithoutCancel(ctx), inventorySaveTimeout)
+	defer cancelSave()
+	if err := e.k8sClient.SaveInventory(saveCtx, e.namespace, e.name, nextInventory); err != nil {
+		result.Errors = append(result.Errors, err)
 	}
 
-	hasDrift, driftReasons := DetectDrift(gitManifests, clusterResources)
+	hasDrift, driftReasons := DetectDrift(declared, clusterResources)
+	result.Drift = hasDrift
+	result.DriftReasons = driftReasons
 	if hasDrift {
 		metrics.DriftDetected.Set(1)
 		for _, reason := range driftReasons {
@@ -124,55 +627,300 @@ func (e *Engine) Sync() (*SyncResult, error) {
 		metrics.DriftDetected.Set(0)
 		log.Info("No drift detected.")
 	}
-	if len(result.Errors) > 0 {
-		metrics.SyncTotal.WithLabelValues("failure").Inc()
-	} else {
-		metrics.SyncTotal.WithLabelValues("success").Inc()
+
+	if err := ctx.Err(); err != nil {
+		metrics.SyncTotal.WithLabelValues(SyncStatusFailure).Inc()
+		log.Errorf("sync of %s interrupted: %v", e.name, err)
+		return nil, fmt.Errorf("sync of %s interrupted: %w", e.name, err)
+	}
+
+	result.Status = result.status()
+	if result.Status == SyncStatusSuccess {
+		e.lastSyncedSHA = commitSHA
 		metrics.LastSyncTimestamp.SetToCurrentTime()
 	}
+	metrics.SyncTotal.WithLabelValues(result.Status).Inc()
 
-	log.Info("--- Sync Complete ---")
+	log.Infof("--- Sync Complete: %s ---", result.Status)
 	return result, nil
 }
 
-func (e *Engine) diff(gitManifests []manifest.Manifest, clusterResources []unstructured.Unstructured) (toApply []manifest.Manifest, toDelete []unstructured.Unstructured) {
+func (r *SyncResult) status() string {
+	switch {
+	case len(r.Errors) > 0:
+		return SyncStatusFailure
+	case !r.Healthy():
+		return SyncStatusDegraded
+	default:
+		return SyncStatusSuccess
+	}
+}
+
+func appendHistory(history []k8s.SyncRecord, record k8s.SyncRecord) []k8s.SyncRecord {
+	history = append(history, record)
+	if len(history) > maxSyncHistory {
+		history = history[len(history)-maxSyncHistory:]
+	}
+	return history
+}
+
+func (r *SyncResult) Healthy() bool {
+	for _, h := range r.Health {
+		if h.Status != HealthHealthy {
+			return false
+		}
+	}
+	return true
+}
+
+func (e *Engine) diff(gitManifests []manifest.Manifest, inventory *k8s.Inventory) (toApply []manifest.Manifest, toPrune []k8s.InventoryEntry) {
 	toApply = gitManifests
 
 	gitManifestsMap := make(map[string]struct{})
 	for _, m := range gitManifests {
-		m.Object.SetNamespace(e.namespace)
-		key := fmt.Sprintf("%s/%s/%s", m.Kind, m.Object.GetNamespace(), m.Name)
+		key := resourceKey(m.Kind, m.Namespace, m.Name)
 		gitManifestsMap[key] = struct{}{}
 	}
 
-	for _, res := range clusterResources {
-		key := fmt.Sprintf("%s/%s/%s", res.GetKind(), res.GetNamespace(), res.GetName())
+	for _, entry := range inventory.Resources {
+		key := resourceKey(entry.Kind, entry.Namespace, entry.Name)
 		if _, exists := gitManifestsMap[key]; !exists {
+			toPrune = append(toPrune, entry)
+		}
+	}
 
-			annotations := res.GetAnnotations()
-			if value, ok := annotations[k8s.PruneAnnotation]; ok && value == "false" {
-				log.Warnf("Skipping prune for %s/%s due to annotation", res.GetKind(), res.GetName())
+	return toApply, toPrune
+}
+
+// applyWaves applies each wave in order and stops at the first wave that
+// fails (or, with waitForHealth, does not become healthy), reporting whether
+// every wave was applied.
+func (e *Engine) applyWaves(ctx context.Context, waves [][]manifest.Manifest, existing map[string]bool, result *SyncResult, dryRun bool) bool {
+	var applied []manifest.Manifest
+	for i, wave := range waves {
+		waveNumber := syncWave(wave[0])
+		log.Infof("--- Applying wave %d (%d/%d): %d resources ---", waveNumber, i+1, len(waves), len(wave))
+
+		var waveApplied []manifest.Manifest
+		failed := false
+		for _, m := range wave {
+			k8s.SetOwner(m.Object, e.name, e.repoPath)
+			if err := e.k8sClient.Apply(ctx, m, dryRun); err != nil {
+				result.Errors = append(result.Errors, err)
+				failed = true
 				continue
 			}
-			toDelete = append(toDelete, res)
+
+			waveApplied = append(waveApplied, m)
+			if !dryRun {
+				metrics.ResourceManaged.WithLabelValues("applied", m.Kind).Inc()
+			}
+			if existing[resourceKey(m.Kind, m.Namespace, m.Name)] {
+				result.Updated = append(result.Updated, m.Name)
+			} else {
+				result.Created = append(result.Created, m.Name)
+			}
+		}
+
+		if failed {
+			log.Errorf("Sync wave %d failed, skipping remaining waves", waveNumber)
+			return false
+		}
+
+		// CRDs are always waited for, as the custom resources after them
+		// cannot be applied, or even scoped, until they are established.
+		crdWave := isCRD(wave[0])
+		if (e.waitForHealth || crdWave) && !dryRun && !e.checkHealth(ctx, waveApplied, result) {
+			log.Errorf("Sync wave %d did not become healthy, skipping remaining waves", waveNumber)
+			return false
+		}
+		applied = append(applied, waveApplied...)
+
+		if crdWave && !dryRun {
+			for _, later := range waves[i+1:] {
+				for j := range later {
+					e.scopeManifest(&later[j])
+				}
+			}
+		}
+	}
+
+	if !e.waitForHealth && !dryRun {
+		e.checkHealth(ctx, applied, result)
+	}
+	return true
+}
+
+// pruneResources deletes the given inventory entries and returns the ones that
+// still exist in the cluster and must stay tracked.
+func (e *Engine) pruneResources(ctx context.Context, toPrune []k8s.InventoryEntry, result *SyncResult, opts applyOptions) []k8s.InventoryEntry {
+	var retained []k8s.InventoryEntry
+	var toDelete []manifest.Manifest
+	entries := make(map[string]k8s.InventoryEntry)
+
+	for _, entry := range toPrune {
+		key := resourceKey(entry.Kind, entry.Namespace, entry.Name)
+		live, err := e.k8sClient.Get(ctx, entry.Manifest())
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			result.Errors = append(result.Errors, err)
+			retained = append(retained, entry)
+			continue
+		}
+
+		if owner := k8s.OwnerOf(live); owner != "" && owner != e.name {
+			log.Warnf("Dropping %s from inventory, now owned by repository %q", key, owner)
+			continue
+		}
+
+		if value, ok := live.GetAnnotations()[k8s.PruneAnnotation]; ok && value == "false" {
+			log.Warnf("Skipping prune for %s/%s due to annotation", entry.Kind, entry.Name)
+			retained = append(retained, entry)
+			continue
+		}
+
+		if !opts.prune {
+			log.Warnf("Prune disabled, leaving orphaned resource: %s", key)
+			result.Orphaned = append(result.Orphaned, key)
+			retained = append(retained, entry)
+			continue
+		}
+
+		entries[key] = entry
+		toDelete = append(toDelete, manifest.Manifest{
+			Kind:      entry.Kind,
+			Name:      entry.Name,
+			Namespace: entry.Namespace,
+			Object:    live,
+		})
+	}
+
+	sortManifests(toDelete, true)
+
+	if opts.dryRun {
+		for _, m := range toDelete {
+			log.Infof("Dry-run: would prune %s", resourceKey(m.Kind, m.Namespace, m.Name))
+			result.Deleted = append(result.Deleted, m.Name)
+		}
+		return retained
+	}
+
+	log.Infof("--- Pruning %d resources ---", len(toDelete))
+	for _, m := range toDelete {
+		if err := e.k8sClient.Delete(ctx, m); err != nil && !apierrors.IsNotFound(err) {
+			result.Errors = append(result.Errors, err)
+			retained = append(retained, entries[resourceKey(m.Kind, m.Namespace, m.Name)])
+		} else {
+			result.Deleted = append(result.Deleted, m.Name)
+			metrics.ResourceManaged.WithLabelValues("deleted", m.Kind).Inc()
+		}
+	}
+
+	return retained
+}
+
+func (e *Engine) loadInventory(ctx context.Context) (*k8s.Inventory, error) {
+	inventory, err := e.k8sClient.GetInventory(ctx, e.namespace, e.name)
+	if err != nil {
+		return nil, err
+	}
+	// An inventory without a commit only holds the suspended flag.
+	if inventory != nil && inventory.Commit != "" {
+		return inventory, nil
+	}
+
+	log.Infof("No inventory found for %s, seeding from labelled resources", e.name)
+	resources, err := e.k8sClient.ListManagedResources(ctx, e.namespace, e.name)
+	if err != nil {
+		return nil, err
+	}
+
+	if inventory == nil {
+		inventory = &k8s.Inventory{}
+	}
+	for i := range resources {
+		inventory.Resources = append(inventory.Resources, k8s.NewInventoryEntry(&resources[i]))
+	}
+	return inventory, nil
+}
+
+// watchedPathsChanged reports whether any file under the repository path or
+// its dependency paths changed between two commits. It errs on the side of
+// syncing when the diff cannot be computed.
+func (e *Engine) watchedPathsChanged(fromSHA, toSHA string) bool {
+	files, err := e.gitRepo.ChangedFiles(fromSHA, toSHA)
+	if err != nil {
+		log.Warnf("Could not compute changed files, syncing anyway: %v", err)
+		return true
+	}
+
+	for _, file := range files {
+		for _, watched := range e.watchPaths {
+			if watched == "." || file == watched || strings.HasPrefix(file, watched+"/") {
+				return true
+			}
 		}
 	}
+	return false
+}
+
+// repoRelativePath turns a configured path into a clean path relative to the
+// repository root, which is how io/fs and changed file names refer to it. A
+// leading slash is accepted and means the same thing.
+func repoRelativePath(p string) string {
+	p = strings.TrimLeft(path.Clean("/"+filepath.ToSlash(p)), "/")
+	if p == "" {
+		return "."
+	}
+	return p
+}
+
+func (e *Engine) renderManifests(repoFS fs.FS, manifestDir string) ([]manifest.Manifest, error) {
+	if e.helm.Enabled {
+		return RenderHelmChart(repoFS, manifestDir, e.name, e.namespace, e.helm)
+	}
+	if IsKustomization(repoFS, manifestDir) {
+		return RenderKustomization(repoFS, manifestDir)
+	}
+	return ParseManifests(repoFS, manifestDir)
+}
+
+func (e *Engine) scopeManifest(m *manifest.Manifest) {
+	namespaced, err := e.k8sClient.IsNamespaced(m.Object.GroupVersionKind())
+	if err != nil {
+		log.Warnf("Could not determine scope of %s/%s, assuming namespaced: %v", m.Kind, m.Name, err)
+		namespaced = true
+	}
+
+	if namespaced {
+		m.Namespace = e.namespace
+	} else {
+		m.Namespace = ""
+	}
+	m.Object.SetNamespace(m.Namespace)
+}
 
-	return toApply, toDelete
+func resourceKey(kind, namespace, name string) string {
+	return fmt.Sprintf("%s/%s/%s", kind, namespace, name)
 }
 
-func (e *Engine) SyncWithRetry(cfg RetryConfig) (*SyncResult, error) {
+func (e *Engine) SyncWithRetry(ctx context.Context, trigger Trigger, cfg RetryConfig) (*SyncResult, error) {
 	b := backoff.NewExponentialBackOff()
 	b.InitialInterval = cfg.InitialDelay
 	b.MaxInterval = cfg.MaxDelay
 
-	retryPolicy := backoff.WithMaxRetries(b, cfg.MaxRetries)
+	retryPolicy := backoff.WithContext(backoff.WithMaxRetries(b, cfg.MaxRetries), ctx)
 
 	var syncResult *SyncResult
 	var syncErr error
 
 	op := func() error {
-		syncResult, syncErr = e.Sync()
+		syncResult, syncErr = e.Sync(ctx, trigger)
+		if errors.Is(syncErr, git.ErrUnverifiedCommit) || errors.Is(syncErr, ErrSuspended) {
+			return backoff.Permanent(syncErr)
+		}
 		return syncErr
 	}
 
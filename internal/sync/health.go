@@ -0,0 +1,291 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/MyoMyatMin/gitops-controller/internal/log"
+	"github.com/MyoMyatMin/gitops-controller/pkg/manifest"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+type HealthStatus string
+
+const (
+	HealthHealthy     HealthStatus = "Healthy"
+	HealthProgressing HealthStatus = "Progressing"
+	HealthDegraded    HealthStatus = "Degraded"
+	HealthMissing     HealthStatus = "Missing"
+	HealthUnknown     HealthStatus = "Unknown"
+)
+
+const (
+	defaultHealthTimeout = 2 * time.Minute
+	healthPollInterval   = 2 * time.Second
+)
+
+// pending reports whether a status may still change to Healthy: the resource
+// is rolling out, was just applied and is not yet visible, or could not be
+// read because of a transient API error.
+func (s HealthStatus) pending() bool {
+	return s == HealthProgressing || s == HealthMissing || s == HealthUnknown
+}
+
+type ResourceHealth struct {
+	Status  HealthStatus
+	Message string
+}
+
+// checkHealth waits until every manifest is healthy or the health timeout
+// expires, records the outcome in result and reports whether all are healthy.
+func (e *Engine) checkHealth(ctx context.Context, manifests []manifest.Manifest, result *SyncResult) bool {
+	if len(manifests) == 0 {
+		return true
+	}
+
+	log.Infof("--- Waiting up to %s for %d resources to become healthy ---", e.healthTimeout, len(manifests))
+
+	deadline := time.Now().Add(e.healthTimeout)
+	health := make(map[string]ResourceHealth)
+poll:
+	for {
+		pending := false
+		for _, m := range manifests {
+			key := resourceKey(m.Kind, m.Namespace, m.Name)
+			if h, ok := health[key]; ok && !h.Status.pending() {
+				continue
+			}
+			h := e.assessHealth(ctx, m)
+			health[key] = h
+			if h.Status.pending() {
+				pending = true
+			}
+		}
+
+		if !pending || time.Now().After(deadline) {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			break poll
+		case <-time.After(healthPollInterval):
+		}
+	}
+
+	healthy := true
+	for key, h := range health {
+		if h.Status == HealthProgressing {
+			h = ResourceHealth{Status: HealthDegraded, Message: fmt.Sprintf("not healthy after %s: %s", e.healthTimeout, h.Message)}
+		}
+		if h.Status != HealthHealthy {
+			healthy = false
+			log.Warnf("Resource %s is %s: %s", key, h.Status, h.Message)
+		}
+		result.Health[key] = h
+	}
+	return healthy
+}
+
+func (e *Engine) assessHealth(ctx context.Context, m manifest.Manifest) ResourceHealth {
+	live, err := e.k8sClient.Get(ctx, m)
+	if apierrors.IsNotFound(err) {
+		return ResourceHealth{Status: HealthMissing, Message: "resource not found"}
+	}
+	if err != nil {
+		return ResourceHealth{Status: HealthUnknown, Message: err.Error()}
+	}
+
+	switch live.GetKind() {
+	case "Deployment":
+		return deploymentHealth(live)
+	case "StatefulSet":
+		return statefulSetHealth(live)
+	case "DaemonSet":
+		return daemonSetHealth(live)
+	case "Job":
+		return jobHealth(live)
+	case "PersistentVolumeClaim":
+		return pvcHealth(live)
+	case "Ingress":
+		return ingressHealth(live)
+	case "Service":
+		return e.serviceHealth(ctx, live)
+	case "CustomResourceDefinition":
+		return crdHealth(live)
+	default:
+		return ResourceHealth{Status: HealthHealthy}
+	}
+}
+
+func deploymentHealth(obj *unstructured.Unstructured) ResourceHealth {
+	if h, ok := generationHealth(obj); !ok {
+		return h
+	}
+	if cond := findCondition(obj, "Progressing"); cond != nil && cond["reason"] == "ProgressDeadlineExceeded" {
+		return ResourceHealth{Status: HealthDegraded, Message: fmt.Sprintf("%v", cond["message"])}
+	}
+
+	replicas := nestedInt(obj, 1, "spec", "replicas")
+	updated := nestedInt(obj, 0, "status", "updatedReplicas")
+	total := nestedInt(obj, 0, "status", "replicas")
+	available := nestedInt(obj, 0, "status", "availableReplicas")
+
+	switch {
+	case updated < replicas:
+		return progressing("%d of %d replicas updated", updated, replicas)
+	case total > updated:
+		return progressing("%d old replicas pending termination", total-updated)
+	case available < updated:
+		return progressing("%d of %d updated replicas available", available, updated)
+	}
+	return ResourceHealth{Status: HealthHealthy}
+}
+
+func statefulSetHealth(obj *unstructured.Unstructured) ResourceHealth {
+	if h, ok := generationHealth(obj); !ok {
+		return h
+	}
+	if strategy, _, _ := unstructured.NestedString(obj.Object, "spec", "updateStrategy", "type"); strategy == "OnDelete" {
+		return ResourceHealth{Status: HealthHealthy}
+	}
+
+	replicas := nestedInt(obj, 1, "spec", "replicas")
+	partition := nestedInt(obj, 0, "spec", "updateStrategy", "rollingUpdate", "partition")
+	updated := nestedInt(obj, 0, "status", "updatedReplicas")
+	ready := nestedInt(obj, 0, "status", "readyReplicas")
+
+	switch {
+	case updated < replicas-partition:
+		return progressing("%d of %d replicas updated", updated, replicas-partition)
+	case ready < replicas:
+		return progressing("%d of %d replicas ready", ready, replicas)
+	}
+
+	if partition == 0 {
+		current, _, _ := unstructured.NestedString(obj.Object, "status", "currentRevision")
+		update, _, _ := unstructured.NestedString(obj.Object, "status", "updateRevision")
+		if current != update {
+			return progressing("waiting for revision %s to replace %s", update, current)
+		}
+	}
+	return ResourceHealth{Status: HealthHealthy}
+}
+
+func daemonSetHealth(obj *unstructured.Unstructured) ResourceHealth {
+	if h, ok := generationHealth(obj); !ok {
+		return h
+	}
+
+	desired := nestedInt(obj, 0, "status", "desiredNumberScheduled")
+	updated := nestedInt(obj, 0, "status", "updatedNumberScheduled")
+	available := nestedInt(obj, 0, "status", "numberAvailable")
+
+	switch {
+	case updated < desired:
+		return progressing("%d of %d pods updated", updated, desired)
+	case available < desired:
+		return progressing("%d of %d pods available", available, desired)
+	}
+	return ResourceHealth{Status: HealthHealthy}
+}
+
+func jobHealth(obj *unstructured.Unstructured) ResourceHealth {
+	if cond := findCondition(obj, "Failed"); cond != nil && cond["status"] == "True" {
+		return ResourceHealth{Status: HealthDegraded, Message: fmt.Sprintf("%v", cond["message"])}
+	}
+	if cond := findCondition(obj, "Complete"); cond != nil && cond["status"] == "True" {
+		return ResourceHealth{Status: HealthHealthy}
+	}
+	return progressing("job has not completed")
+}
+
+func crdHealth(obj *unstructured.Unstructured) ResourceHealth {
+	if cond := findCondition(obj, "NamesAccepted"); cond != nil && cond["status"] == "False" {
+		return ResourceHealth{Status: HealthDegraded, Message: fmt.Sprintf("%v", cond["message"])}
+	}
+	if cond := findCondition(obj, "Established"); cond != nil && cond["status"] == "True" {
+		return ResourceHealth{Status: HealthHealthy}
+	}
+	return progressing("waiting for the definition to be established")
+}
+
+func pvcHealth(obj *unstructured.Unstructured) ResourceHealth {
+	phase, _, _ := unstructured.NestedString(obj.Object, "status", "phase")
+	switch phase {
+	case "Bound":
+		return ResourceHealth{Status: HealthHealthy}
+	case "Lost":
+		return ResourceHealth{Status: HealthDegraded, Message: "claim lost its underlying volume"}
+	}
+	return progressing("claim is %s", phase)
+}
+
+func ingressHealth(obj *unstructured.Unstructured) ResourceHealth {
+	if !hasLoadBalancerIngress(obj) {
+		return progressing("waiting for an address to be assigned")
+	}
+	return ResourceHealth{Status: HealthHealthy}
+}
+
+func (e *Engine) serviceHealth(ctx context.Context, obj *unstructured.Unstructured) ResourceHealth {
+	serviceType, _, _ := unstructured.NestedString(obj.Object, "spec", "type")
+	if serviceType == "ExternalName" {
+		return ResourceHealth{Status: HealthHealthy}
+	}
+	if serviceType == "LoadBalancer" && !hasLoadBalancerIngress(obj) {
+		return progressing("waiting for a load balancer address")
+	}
+
+	selector, _, _ := unstructured.NestedStringMap(obj.Object, "spec", "selector")
+	if len(selector) == 0 {
+		return ResourceHealth{Status: HealthHealthy}
+	}
+
+	ready, err := e.k8sClient.HasReadyEndpoints(ctx, obj.GetNamespace(), obj.GetName())
+	if err != nil {
+		return ResourceHealth{Status: HealthUnknown, Message: err.Error()}
+	}
+	if !ready {
+		return progressing("no ready endpoints")
+	}
+	return ResourceHealth{Status: HealthHealthy}
+}
+
+// generationHealth reports ok=false with a progressing status while the
+// controller has not yet observed the latest spec.
+func generationHealth(obj *unstructured.Unstructured) (ResourceHealth, bool) {
+	observed := nestedInt(obj, 0, "status", "observedGeneration")
+	if observed < obj.GetGeneration() {
+		return progressing("waiting for generation %d to be observed", obj.GetGeneration()), false
+	}
+	return ResourceHealth{}, true
+}
+
+func hasLoadBalancerIngress(obj *unstructured.Unstructured) bool {
+	ingress, _, _ := unstructured.NestedSlice(obj.Object, "status", "loadBalancer", "ingress")
+	return len(ingress) > 0
+}
+
+func findCondition(obj *unstructured.Unstructured, conditionType string) map[string]interface{} {
+	conditions, _, _ := unstructured.NestedSlice(obj.Object, "status", "conditions")
+	for _, c := range conditions {
+		if cond, ok := c.(map[string]interface{}); ok && cond["type"] == conditionType {
+			return cond
+		}
+	}
+	return nil
+}
+
+func nestedInt(obj *unstructured.Unstructured, fallback int64, fields ...string) int64 {
+	value, found, err := unstructured.NestedInt64(obj.Object, fields...)
+	if !found || err != nil {
+		return fallback
+	}
+	return value
+}
+
+func progressing(format string, args ...interface{}) ResourceHealth {
+	return ResourceHealth{Status: HealthProgressing, Message: fmt.Sprintf(format, args...)}
+}
@@ -0,0 +1,261 @@
+package sync
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/MyoMyatMin/gitops-controller/internal/config"
+	"github.com/MyoMyatMin/gitops-controller/internal/k8s"
+	"github.com/MyoMyatMin/gitops-controller/internal/log"
+	"github.com/MyoMyatMin/gitops-controller/pkg/manifest"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/engine"
+	"helm.sh/helm/v3/pkg/ignore"
+)
+
+// helmHookAnnotation marks a template as a Helm hook rather than part of the release.
+const helmHookAnnotation = "helm.sh/hook"
+
+// RenderHelmChart renders the chart committed at chartDir, including any
+// dependencies vendored under charts/, without contacting a chart repository.
+// Hooks are handled as described at helmHookWave.
+func RenderHelmChart(fsys fs.FS, chartDir, releaseName, namespace string, cfg config.HelmConfig) ([]manifest.Manifest, error) {
+	log.Infof("Rendering Helm chart in: %s", chartDir)
+
+	chrt, err := loadChart(fsys, chartDir)
+	if err != nil {
+		log.Errorf("error loading chart %s: %v", chartDir, err)
+		return nil, fmt.Errorf("error loading chart %s: %w", chartDir, err)
+	}
+
+	if err := checkDependencies(chrt); err != nil {
+		return nil, err
+	}
+
+	vals, err := helmValues(fsys, chartDir, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := chartutil.ProcessDependenciesWithMerge(chrt, vals); err != nil {
+		return nil, fmt.Errorf("error processing chart dependencies: %w", err)
+	}
+
+	if cfg.ReleaseName != "" {
+		releaseName = cfg.ReleaseName
+	}
+	options := chartutil.ReleaseOptions{
+		Name:      releaseName,
+		Namespace: namespace,
+		Revision:  1,
+		IsInstall: true,
+	}
+
+	renderValues, err := chartutil.ToRenderValues(chrt, vals, options, chartutil.DefaultCapabilities)
+	if err != nil {
+		return nil, fmt.Errorf("error building render values: %w", err)
+	}
+
+	rendered, err := engine.Render(chrt, renderValues)
+	if err != nil {
+		log.Errorf("error rendering chart %s: %v", chartDir, err)
+		return nil, fmt.Errorf("error rendering chart %s: %w", chartDir, err)
+	}
+
+	var allManifests []manifest.Manifest
+	for _, crd := range chrt.CRDObjects() {
+		manifests, err := ParseYAML(crd.File.Data)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing CRD %s: %w", crd.Filename, err)
+		}
+		allManifests = append(allManifests, manifests...)
+	}
+
+	names := make([]string, 0, len(rendered))
+	for name := range rendered {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		base := path.Base(name)
+		if strings.HasPrefix(base, "_") || base == "NOTES.txt" {
+			continue
+		}
+
+		manifests, err := ParseYAML([]byte(rendered[name]))
+		if err != nil {
+			return nil, fmt.Errorf("error parsing rendered template %s: %w", name, err)
+		}
+		for _, m := range manifests {
+			m.FilePath = name
+			wave, keep := helmHookWave(m)
+			if !keep {
+				log.Infof("Skipping Helm hook %s/%s (%s)", m.Kind, m.Name, m.Object.GetAnnotations()[helmHookAnnotation])
+				continue
+			}
+			if wave != "" {
+				annotations := m.Object.GetAnnotations()
+				annotations[k8s.SyncWaveAnnotation] = wave
+				m.Object.SetAnnotations(annotations)
+			}
+			allManifests = append(allManifests, m)
+		}
+	}
+
+	log.Infof("Finished rendering. Found %d manifests.", len(allManifests))
+	return allManifests, nil
+}
+
+// helmHookWave decides how a rendered template annotated as a Helm hook is
+// synced, as there is no install or upgrade for hooks to run around:
+//   - pre-install and pre-upgrade hooks are applied in sync wave -1, and
+//     post-install and post-upgrade hooks in wave 1, unless the template sets
+//     its own sync wave. Hook weights and delete policies are ignored.
+//   - test, delete and rollback hooks are dropped (keep is false).
+//
+// An empty wave leaves the manifest as it is.
+func helmHookWave(m manifest.Manifest) (wave string, keep bool) {
+	value, ok := m.Object.GetAnnotations()[helmHookAnnotation]
+	if !ok {
+		return "", true
+	}
+
+	var pre, post bool
+	for _, hook := range strings.Split(value, ",") {
+		switch strings.TrimSpace(hook) {
+		case "pre-install", "pre-upgrade":
+			pre = true
+		case "post-install", "post-upgrade":
+			post = true
+		}
+	}
+	if !pre && !post {
+		return "", false
+	}
+	if _, ok := m.Object.GetAnnotations()[k8s.SyncWaveAnnotation]; ok {
+		return "", true
+	}
+	if pre {
+		return "-1", true
+	}
+	return "1", true
+}
+
+func loadChart(fsys fs.FS, chartDir string) (*chart.Chart, error) {
+	rules := ignore.Empty()
+	if data, err := fs.ReadFile(fsys, path.Join(chartDir, ignore.HelmIgnore)); err == nil {
+		rules, err = ignore.Parse(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("error parsing %s: %w", ignore.HelmIgnore, err)
+		}
+	}
+	rules.AddDefaults()
+
+	var files []*loader.BufferedFile
+	err := fs.WalkDir(fsys, chartDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == chartDir {
+			return nil
+		}
+		name := p
+		if chartDir != "." {
+			name = strings.TrimPrefix(p, chartDir+"/")
+		}
+		if d.IsDir() && d.Name() == ".git" {
+			return fs.SkipDir
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if rules.Ignore(name, info) {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if rules.Ignore(name, info) {
+			return nil
+		}
+
+		data, err := fs.ReadFile(fsys, p)
+		if err != nil {
+			return fmt.Errorf("error reading %s: %w", name, err)
+		}
+		files = append(files, &loader.BufferedFile{Name: name, Data: data})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return loader.LoadFiles(files)
+}
+
+func helmValues(fsys fs.FS, chartDir string, cfg config.HelmConfig) (map[string]interface{}, error) {
+	vals := map[string]interface{}{}
+
+	for _, file := range cfg.ValuesFiles {
+		data, err := fs.ReadFile(fsys, path.Join(chartDir, file))
+		if err != nil {
+			return nil, fmt.Errorf("error reading values file %s: %w", file, err)
+		}
+		fileVals, err := chartutil.ReadValues(data)
+		if err != nil {
+			return nil, fmt.Errorf("error reading values file %s: %w", file, err)
+		}
+		vals = mergeValues(vals, fileVals)
+	}
+
+	if cfg.Values != "" {
+		inlineVals, err := chartutil.ReadValues([]byte(cfg.Values))
+		if err != nil {
+			return nil, fmt.Errorf("error parsing inline values: %w", err)
+		}
+		vals = mergeValues(vals, inlineVals)
+	}
+
+	return vals, nil
+}
+
+func mergeValues(dst, src map[string]interface{}) map[string]interface{} {
+	for key, srcVal := range src {
+		if srcMap, ok := srcVal.(map[string]interface{}); ok {
+			if dstMap, ok := dst[key].(map[string]interface{}); ok {
+				dst[key] = mergeValues(dstMap, srcMap)
+				continue
+			}
+		}
+		dst[key] = srcVal
+	}
+	return dst
+}
+
+func checkDependencies(chrt *chart.Chart) error {
+	vendored := make(map[string]struct{})
+	for _, dep := range chrt.Dependencies() {
+		vendored[dep.Name()] = struct{}{}
+	}
+
+	var missing []string
+	for _, dep := range chrt.Metadata.Dependencies {
+		if _, ok := vendored[dep.Name]; !ok {
+			missing = append(missing, dep.Name)
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("chart %s has dependencies not vendored under charts/: %s", chrt.Name(), strings.Join(missing, ", "))
+	}
+	return nil
+}
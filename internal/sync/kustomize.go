@@ -0,0 +1,79 @@
+package sync
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+
+	"github.com/MyoMyatMin/gitops-controller/internal/log"
+	"github.com/MyoMyatMin/gitops-controller/pkg/manifest"
+	"sigs.k8s.io/kustomize/api/konfig"
+	"sigs.k8s.io/kustomize/api/krusty"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+)
+
+func IsKustomization(fsys fs.FS, dirPath string) bool {
+	for _, name := range konfig.RecognizedKustomizationFileNames() {
+		if _, err := fs.Stat(fsys, path.Join(dirPath, name)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// RenderKustomization builds the kustomization at dirPath. The whole of fsys
+// is made available so bases elsewhere in the repository can be referenced.
+func RenderKustomization(fsys fs.FS, dirPath string) ([]manifest.Manifest, error) {
+	log.Infof("Rendering kustomization in: %s", dirPath)
+
+	kfs, err := toKustomizeFS(fsys)
+	if err != nil {
+		return nil, fmt.Errorf("error loading repository files: %w", err)
+	}
+
+	kustomizer := krusty.MakeKustomizer(krusty.MakeDefaultOptions())
+	resMap, err := kustomizer.Run(kfs, path.Join("/", dirPath))
+	if err != nil {
+		log.Errorf("error building kustomization %s: %v", dirPath, err)
+		return nil, fmt.Errorf("error building kustomization %s: %w", dirPath, err)
+	}
+
+	data, err := resMap.AsYaml()
+	if err != nil {
+		return nil, fmt.Errorf("error serializing kustomization output: %w", err)
+	}
+
+	manifests, err := ParseYAML(data)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing kustomization output: %w", err)
+	}
+
+	log.Infof("Finished rendering. Found %d manifests.", len(manifests))
+	return manifests, nil
+}
+
+func toKustomizeFS(fsys fs.FS) (filesys.FileSystem, error) {
+	kfs := filesys.MakeFsInMemory()
+
+	err := fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == ".git" {
+				return fs.SkipDir
+			}
+			return kfs.MkdirAll(path.Join("/", p))
+		}
+
+		data, err := fs.ReadFile(fsys, p)
+		if err != nil {
+			return err
+		}
+		return kfs.WriteFile(path.Join("/", p), data)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return kfs, nil
+}
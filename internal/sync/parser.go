@@ -2,8 +2,7 @@ package sync
 
 import (
 	"fmt"
-	"os"
-	"path/filepath"
+	"io/fs"
 	"strings"
 
 	"github.com/MyoMyatMin/gitops-controller/internal/log"
@@ -12,19 +11,31 @@ import (
 	"sigs.k8s.io/yaml"
 )
 
-func ParseManifests(dirPath string) ([]manifest.Manifest, error) {
+func ParseManifests(fsys fs.FS, dirPath string) ([]manifest.Manifest, error) {
 	var allManifests []manifest.Manifest
 
 	log.Infof("Starting to parse manifests in: %s", dirPath)
 
-	err := filepath.Walk(dirPath, func(path string, info os.FileInfo, walkErr error) error {
+	err := fs.WalkDir(fsys, dirPath, func(path string, d fs.DirEntry, walkErr error) error {
 
 		if walkErr != nil {
+			// An unreadable manifest directory must not look like an empty
+			// one, which would prune everything.
+			if path == dirPath {
+				return walkErr
+			}
 			log.Warnf("Skipping file %s (walk error: %v)", path, walkErr)
 			return nil
 		}
 
-		data, err := os.ReadFile(path)
+		if d.IsDir() {
+			if d.Name() == ".git" {
+				return fs.SkipDir
+			}
+			return nil
+		}
+
+		data, err := fs.ReadFile(fsys, path)
 		if err != nil {
 
 			log.Warnf("Skipping file %s (read error: %v)", path, err)
@@ -38,6 +49,9 @@ func ParseManifests(dirPath string) ([]manifest.Manifest, error) {
 			return nil
 		}
 
+		for i := range manifests {
+			manifests[i].FilePath = path
+		}
 		allManifests = append(allManifests, manifests...)
 		return nil
 	})
@@ -1,6 +1,8 @@
 package sync
 
 import (
+	"context"
+	"errors"
 	"sync"
 	"time"
 
@@ -8,6 +10,10 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+// syncRequestInterval is how often the leader checks for syncs requested by
+// followers that received a webhook.
+const syncRequestInterval = 10 * time.Second
+
 type Poller struct {
 	engine        *Engine
 	interval      time.Duration
@@ -25,29 +31,45 @@ func NewPoller(engine *Engine, interval time.Duration) *Poller {
 	}
 }
 
-func (p *Poller) Start() {
+// Start polls until ctx is cancelled or Stop is called, either of which also
+// cancels any sync in flight.
+func (p *Poller) Start(ctx context.Context) {
 	log.Infof("Starting poller: checking for updates every %s", p.interval)
 
 	p.wg.Add(1)
 	defer p.wg.Done()
 
-	p.poll()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-p.stopCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	p.poll(ctx)
 
 	ticker := time.NewTicker(p.interval)
 	defer ticker.Stop()
+	requests := time.NewTicker(syncRequestInterval)
+	defer requests.Stop()
 
 	for {
 		select {
 		case <-ticker.C:
-			p.poll()
-		case <-p.stopCh:
+			p.poll(ctx)
+		case <-requests.C:
+			p.syncRequested(ctx)
+		case <-ctx.Done():
 			log.Info("Stopping poller.")
 			return
 		}
 	}
 }
 
-func (p *Poller) poll() {
+func (p *Poller) poll(ctx context.Context) {
 	log.Info("Polling for changes....")
 
 	retryConfig := RetryConfig{
@@ -56,45 +78,77 @@ func (p *Poller) poll() {
 		MaxDelay:     30 * time.Second,
 	}
 
-	hasChanges, err := p.engine.gitRepo.HasChanges()
-	if err != nil {
-		log.Errorf("Error checking for changes: %v", err)
+	if err := p.engine.EnsureCloned(ctx); err != nil {
+		log.Errorf("Error cloning repository %s: %v", p.engine.Name(), err)
 		return
 	}
 
-	if !hasChanges {
-		log.Info("No new commits found.")
+	if err := p.engine.EnsureStateLoaded(ctx); err != nil {
+		log.Errorf("Error loading state of %s: %v", p.engine.Name(), err)
 		return
 	}
 
-	if err := p.engine.gitRepo.Pull(); err != nil {
-		log.Errorf("Error pulling repo: %v", err)
+	if p.engine.Suspended() {
+		log.Infof("Auto-sync suspended for %s, skipping poll.", p.engine.Name())
 		return
 	}
 
-	latestSHA, err := p.engine.gitRepo.GetLatestCommit()
+	// The first sync runs unconditionally so the cluster is reconciled after
+	// a restart even when the clone is already up to date.
+	if p.engine.Status().SyncAttempted {
+		hasChanges, err := p.engine.HasChanges(ctx)
+		if err != nil {
+			log.Errorf("Error checking for changes: %v", err)
+			return
+		}
+
+		if !hasChanges {
+			log.Info("No new commits found.")
+			return
+		}
+
+		log.Info("New commit found. Starting to sync.")
+	} else {
+		log.Infof("Starting initial sync of %s.", p.engine.Name())
+	}
+
+	result, err := p.engine.SyncWithRetry(ctx, TriggerPoll, retryConfig)
+	if errors.Is(err, ErrSuspended) {
+		log.Infof("Auto-sync suspended for %s, skipping sync.", p.engine.Name())
+		return
+	}
 	if err != nil {
-		log.Errorf("Error checking git commit: %v", err)
+		log.Errorf("Sync failed: %v", err)
 		return
 	}
 
 	log.WithFields(logrus.Fields{
-		"new_commit": latestSHA,
-	}).Info("New commit found. Starting to sync.")
+		"commit":   result.CommitSHA,
+		"ref":      result.Ref,
+		"trigger":  result.Trigger,
+		"status":   result.Status,
+		"skipped":  result.Skipped,
+		"updated":  len(result.Updated),
+		"deleted":  len(result.Deleted),
+		"orphaned": len(result.Orphaned),
+		"errors":   len(result.Errors),
+	}).Info("Sync complete")
+
+	p.lastCommitSHA = result.CommitSHA
+}
 
-	result, err := p.engine.SyncWithRetry(retryConfig)
+func (p *Poller) syncRequested(ctx context.Context) {
+	if !p.engine.Status().Cloned {
+		return
+	}
+	requested, err := p.engine.SyncRequested(ctx)
 	if err != nil {
-		log.Errorf("Sync failed: %v", err)
-	} else {
-		log.WithFields(logrus.Fields{
-			"commit":  result.CommitSHA,
-			"updated": len(result.Updated),
-			"deleted": len(result.Deleted),
-			"errors":  len(result.Errors),
-		}).Info("Sync complete")
+		log.Errorf("Error handling sync request for %s: %v", p.engine.Name(), err)
+		return
+	}
+	if requested {
+		log.Infof("Requested sync of %s complete.", p.engine.Name())
 	}
-
-	p.lastCommitSHA = latestSHA
 }
 
 func (p *Poller) Stop() {
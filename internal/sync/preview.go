@@ -0,0 +1,352 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/MyoMyatMin/gitops-controller/internal/k8s"
+	"github.com/MyoMyatMin/gitops-controller/internal/log"
+	"github.com/MyoMyatMin/gitops-controller/pkg/manifest"
+	"github.com/pmezard/go-difflib/difflib"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+)
+
+// Actions a sync would take on a declared resource.
+const (
+	DiffCreate    = "create"
+	DiffUpdate    = "update"
+	DiffUnchanged = "unchanged"
+)
+
+// Placeholders for Secret values, which are never shown.
+const (
+	secretUnchanged = "********"
+	secretLive      = "--------"
+	secretDesired   = "++++++++"
+)
+
+// lastAppliedAnnotation is set by kubectl and would show up as noise.
+const lastAppliedAnnotation = "kubectl.kubernetes.io/last-applied-configuration"
+
+// FieldChange is one field that differs between the live object and the
+// dry-run result. Live is nil for added fields and Desired for removed ones.
+type FieldChange struct {
+	Path    string
+	Live    interface{}
+	Desired interface{}
+}
+
+type ResourceDiff struct {
+	Kind      string
+	Namespace string
+	Name      string
+	Action    string
+	Fields    []FieldChange
+	// Diff is a unified diff of the live and desired object as YAML.
+	Diff string
+}
+
+// DiffResult previews what syncing a commit would change in the cluster.
+type DiffResult struct {
+	Repository string
+	CommitSHA  string
+	Ref        string
+	Resources  []ResourceDiff
+	// PruneCandidates are inventoried resources the commit no longer declares.
+	// They are only deleted when Prune is set.
+	PruneCandidates []string
+	Prune           bool
+	Errors          []error
+}
+
+// HasChanges reports whether a sync would create, update or prune anything.
+func (d *DiffResult) HasChanges() bool {
+	for _, r := range d.Resources {
+		if r.Action != DiffUnchanged {
+			return true
+		}
+	}
+	return d.Prune && len(d.PruneCandidates) > 0
+}
+
+// WriteUnified writes the per-resource diffs followed by the prune candidates.
+func (d *DiffResult) WriteUnified(w io.Writer) error {
+	for _, r := range d.Resources {
+		if r.Diff == "" {
+			continue
+		}
+		if _, err := io.WriteString(w, r.Diff); err != nil {
+			return err
+		}
+	}
+	for _, key := range d.PruneCandidates {
+		action := "orphan (prune disabled)"
+		if d.Prune {
+			action = "prune"
+		}
+		if _, err := fmt.Fprintf(w, "# %s: %s\n", action, key); err != nil {
+			return err
+		}
+	}
+	for _, err := range d.Errors {
+		if _, werr := fmt.Fprintf(w, "# error: %v\n", err); werr != nil {
+			return werr
+		}
+	}
+	return nil
+}
+
+// Diff renders revision, or the tracked ref when empty, server-side dry-run
+// applies every resource and compares the result with the live objects.
+// Nothing is changed in the cluster or the worktree.
+func (e *Engine) Diff(ctx context.Context, revision string) (*DiffResult, error) {
+	e.lockSync()
+	defer e.unlockSync()
+
+	ctx, cancel := context.WithTimeout(ctx, e.syncTimeout)
+	defer cancel()
+
+	commitSHA, ref, err := e.gitRepo.Resolve(ctx, revision)
+	if err != nil {
+		log.Errorf("error resolving revision: %v", err)
+		return nil, fmt.Errorf("error resolving revision: %w", err)
+	}
+	repoFS, err := e.gitRepo.CommitFS(commitSHA)
+	if err != nil {
+		log.Errorf("error reading commit %s: %v", commitSHA, err)
+		return nil, fmt.Errorf("error reading commit %s: %w", commitSHA, err)
+	}
+
+	gitManifests, err := e.renderManifests(repoFS, e.repoPath)
+	if err != nil {
+		log.Errorf("error parsing manifests: %v", err)
+		return nil, fmt.Errorf("error parsing manifests: %w", err)
+	}
+	for i := range gitManifests {
+		e.scopeManifest(&gitManifests[i])
+	}
+
+	inventory, err := e.loadInventory(ctx)
+	if err != nil {
+		log.Errorf("error loading inventory: %v", err)
+		return nil, fmt.Errorf("error loading inventory: %w", err)
+	}
+
+	log.Infof("Diffing %s at %s (%s)", e.name, commitSHA, ref)
+	result := &DiffResult{Repository: e.name, CommitSHA: commitSHA, Ref: ref, Prune: e.prune}
+
+	var declared []manifest.Manifest
+	for _, m := range gitManifests {
+		key := resourceKey(m.Kind, m.Namespace, m.Name)
+		live, err := e.k8sClient.Get(ctx, m)
+		if apierrors.IsNotFound(err) {
+			live = nil
+		} else if err != nil {
+			result.Errors = append(result.Errors, fmt.Errorf("error getting live state of %s: %w", key, err))
+			continue
+		}
+
+		if live != nil {
+			if owner := k8s.OwnerOf(live); owner != "" && owner != e.name {
+				result.Errors = append(result.Errors, fmt.Errorf("ownership conflict: %s is declared by repository %q but owned by repository %q", key, e.name, owner))
+				continue
+			}
+		}
+		declared = append(declared, m)
+
+		k8s.SetOwner(m.Object, e.name, e.repoPath)
+		desired, err := e.k8sClient.DryRunApply(ctx, m)
+		if err != nil {
+			result.Errors = append(result.Errors, fmt.Errorf("error dry-run applying %s: %w", key, err))
+			continue
+		}
+
+		rd, err := diffResource(m, live, desired)
+		if err != nil {
+			result.Errors = append(result.Errors, fmt.Errorf("error diffing %s: %w", key, err))
+			continue
+		}
+		result.Resources = append(result.Resources, rd)
+	}
+
+	_, toPrune := e.diff(declared, inventory)
+	for _, entry := range toPrune {
+		key := resourceKey(entry.Kind, entry.Namespace, entry.Name)
+		live, err := e.k8sClient.Get(ctx, entry.Manifest())
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			result.Errors = append(result.Errors, fmt.Errorf("error getting live state of %s: %w", key, err))
+			continue
+		}
+		if owner := k8s.OwnerOf(live); owner != "" && owner != e.name {
+			continue
+		}
+		if live.GetAnnotations()[k8s.PruneAnnotation] == "false" {
+			continue
+		}
+		result.PruneCandidates = append(result.PruneCandidates, key)
+	}
+
+	return result, nil
+}
+
+func diffResource(m manifest.Manifest, live, desired *unstructured.Unstructured) (ResourceDiff, error) {
+	rd := ResourceDiff{Kind: m.Kind, Namespace: m.Namespace, Name: m.Name, Action: DiffUnchanged}
+
+	liveObj := normalizeForDiff(live)
+	desiredObj := normalizeForDiff(desired)
+	if m.Kind == "Secret" {
+		redactSecretData(liveObj, desiredObj)
+	}
+
+	if live == nil {
+		rd.Action = DiffCreate
+	} else {
+		rd.Fields = diffFields("", liveObj, desiredObj)
+		if len(rd.Fields) > 0 {
+			rd.Action = DiffUpdate
+		}
+	}
+	if rd.Action == DiffUnchanged {
+		return rd, nil
+	}
+
+	diff, err := unifiedDiff(resourceKey(m.Kind, m.Namespace, m.Name), liveObj, desiredObj)
+	if err != nil {
+		return rd, err
+	}
+	rd.Diff = diff
+	return rd, nil
+}
+
+// normalizeForDiff drops the server-managed fields that change on every apply.
+func normalizeForDiff(obj *unstructured.Unstructured) map[string]interface{} {
+	if obj == nil {
+		return nil
+	}
+	normalized := obj.DeepCopy().Object
+	delete(normalized, "status")
+	for _, field := range []string{"managedFields", "resourceVersion", "generation", "uid", "creationTimestamp"} {
+		unstructured.RemoveNestedField(normalized, "metadata", field)
+	}
+	unstructured.RemoveNestedField(normalized, "metadata", "annotations", lastAppliedAnnotation)
+	if annotations, _, _ := unstructured.NestedMap(normalized, "metadata", "annotations"); len(annotations) == 0 {
+		unstructured.RemoveNestedField(normalized, "metadata", "annotations")
+	}
+	return normalized
+}
+
+// redactSecretData replaces Secret values with placeholders that only show
+// whether each value changed.
+func redactSecretData(live, desired map[string]interface{}) {
+	for _, field := range []string{"data", "stringData"} {
+		liveData, _ := live[field].(map[string]interface{})
+		desiredData, _ := desired[field].(map[string]interface{})
+		for key, liveValue := range liveData {
+			desiredValue, ok := desiredData[key]
+			switch {
+			case !ok:
+				liveData[key] = secretLive
+			case reflect.DeepEqual(liveValue, desiredValue):
+				liveData[key] = secretUnchanged
+				desiredData[key] = secretUnchanged
+			default:
+				liveData[key] = secretLive
+				desiredData[key] = secretDesired
+			}
+		}
+		for key := range desiredData {
+			if _, ok := liveData[key]; !ok {
+				desiredData[key] = secretDesired
+			}
+		}
+	}
+}
+
+// diffFields lists the leaf fields that differ between live and desired.
+func diffFields(fieldPath string, live, desired interface{}) []FieldChange {
+	liveMap, liveIsMap := live.(map[string]interface{})
+	desiredMap, desiredIsMap := desired.(map[string]interface{})
+	if liveIsMap && desiredIsMap {
+		keys := make(map[string]struct{})
+		for key := range liveMap {
+			keys[key] = struct{}{}
+		}
+		for key := range desiredMap {
+			keys[key] = struct{}{}
+		}
+		sorted := make([]string, 0, len(keys))
+		for key := range keys {
+			sorted = append(sorted, key)
+		}
+		sort.Strings(sorted)
+
+		var changes []FieldChange
+		for _, key := range sorted {
+			changes = append(changes, diffFields(fieldPath+"."+key, liveMap[key], desiredMap[key])...)
+		}
+		return changes
+	}
+
+	liveList, liveIsList := live.([]interface{})
+	desiredList, desiredIsList := desired.([]interface{})
+	if liveIsList && desiredIsList {
+		var changes []FieldChange
+		for i := 0; i < max(len(liveList), len(desiredList)); i++ {
+			var liveItem, desiredItem interface{}
+			if i < len(liveList) {
+				liveItem = liveList[i]
+			}
+			if i < len(desiredList) {
+				desiredItem = desiredList[i]
+			}
+			changes = append(changes, diffFields(fieldPath+"["+strconv.Itoa(i)+"]", liveItem, desiredItem)...)
+		}
+		return changes
+	}
+
+	if reflect.DeepEqual(live, desired) {
+		return nil
+	}
+	return []FieldChange{{Path: fieldPath, Live: live, Desired: desired}}
+}
+
+func unifiedDiff(key string, live, desired map[string]interface{}) (string, error) {
+	var liveLines []string
+	if live != nil {
+		liveYAML, err := yaml.Marshal(live)
+		if err != nil {
+			return "", err
+		}
+		liveLines = yamlLines(liveYAML)
+	}
+	desiredYAML, err := yaml.Marshal(desired)
+	if err != nil {
+		return "", err
+	}
+
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        liveLines,
+		B:        yamlLines(desiredYAML),
+		FromFile: "live/" + key,
+		ToFile:   "desired/" + key,
+		Context:  3,
+	})
+}
+
+func yamlLines(data []byte) []string {
+	lines := strings.SplitAfter(string(data), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
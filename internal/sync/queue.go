@@ -0,0 +1,94 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/MyoMyatMin/gitops-controller/internal/log"
+)
+
+// Trigger records what asked for a sync.
+type Trigger string
+
+const (
+	TriggerPoll    Trigger = "poll"
+	TriggerWebhook Trigger = "webhook"
+	TriggerManual  Trigger = "manual"
+)
+
+// ErrSuspended is returned for automatic syncs of a suspended repository.
+var ErrSuspended = errors.New("auto-sync is suspended")
+
+// syncRequest is a queued sync that any number of triggers may wait on.
+type syncRequest struct {
+	trigger Trigger
+	done    chan struct{}
+	result  *SyncResult
+	err     error
+}
+
+// Sync pulls the configured ref and applies it. Syncs of one engine never run
+// concurrently: while one is running, further triggers are coalesced into a
+// single pending sync whose result they all share. The pending sync runs under
+// the engine's context; ctx only bounds how long the caller waits for it.
+func (e *Engine) Sync(ctx context.Context, trigger Trigger) (*SyncResult, error) {
+	e.queueMu.Lock()
+	req := e.pending
+	if req != nil {
+		if trigger == TriggerManual {
+			req.trigger = TriggerManual
+		}
+		log.Infof("Sync of %s already pending (%s), coalescing %s trigger", e.name, req.trigger, trigger)
+	} else {
+		req = &syncRequest{trigger: trigger, done: make(chan struct{})}
+		e.pending = req
+		e.inflight.Add(1)
+		go e.runPending(e.runCtx, req)
+	}
+	e.queueMu.Unlock()
+
+	select {
+	case <-req.done:
+		return req.result, req.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (e *Engine) runPending(ctx context.Context, req *syncRequest) {
+	defer e.inflight.Done()
+	defer close(req.done)
+
+	e.lockSync()
+	defer e.unlockSync()
+
+	e.queueMu.Lock()
+	e.pending = nil
+	trigger := req.trigger
+	e.queueMu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		req.err = err
+		return
+	}
+	if trigger != TriggerManual && e.Suspended() {
+		req.err = ErrSuspended
+		return
+	}
+	startedAt := time.Now()
+	// Manual syncs apply even when the watched paths are unchanged.
+	req.result, req.err = e.pullAndSync(ctx, applyOptions{skipUnchanged: trigger != TriggerManual, prune: e.prune})
+	e.recordSync(trigger, startedAt, req.result, req.err)
+}
+
+// HasChanges reports whether the remote ref has moved past the checked out
+// commit. It waits for any running sync so the two never fetch concurrently.
+func (e *Engine) HasChanges(ctx context.Context) (bool, error) {
+	e.lockSync()
+	defer e.unlockSync()
+
+	ctx, cancel := context.WithTimeout(ctx, e.syncTimeout)
+	defer cancel()
+	return e.gitRepo.HasChanges(ctx)
+}
@@ -0,0 +1,187 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/MyoMyatMin/gitops-controller/internal/k8s"
+	"github.com/MyoMyatMin/gitops-controller/internal/log"
+)
+
+// maxRecentSyncs bounds the in-memory history of sync results kept per engine.
+const maxRecentSyncs = 100
+
+// EngineStatus is a point-in-time view of an engine for status reporting.
+type EngineStatus struct {
+	Name          string
+	URL           string
+	Ref           string
+	Namespace     string
+	Path          string
+	Suspended     bool
+	LastSyncedSHA string
+	// LastSyncTime is when the last successful sync finished.
+	LastSyncTime time.Time
+	LastResult   *SyncResult
+	// Drift is as of the last sync that compared declared and live state.
+	Drift        bool
+	DriftReasons []string
+	// Cloned, Cloning, CloneError and SyncAttempted report progress through
+	// startup. CloneError is that of the last failed clone attempt.
+	Cloned        bool
+	Cloning       bool
+	CloneError    error
+	SyncAttempted bool
+	// BusySince is when the running sync, fetch or diff started, if any.
+	BusySince time.Time
+}
+
+// lockSync serializes the engine's git and cluster operations and records
+// when the current one started.
+func (e *Engine) lockSync() {
+	e.syncMu.Lock()
+	e.statusMu.Lock()
+	e.busySince = time.Now()
+	e.statusMu.Unlock()
+}
+
+func (e *Engine) unlockSync() {
+	e.statusMu.Lock()
+	e.busySince = time.Time{}
+	e.statusMu.Unlock()
+	e.syncMu.Unlock()
+}
+
+// recordSync stamps a finished sync and adds it to the recent history. Syncs
+// that failed without a result are recorded as failures carrying err. Other
+// than dry runs, the outcome is saved to the inventory for the replicas that
+// do not sync. It must be called with syncMu held.
+func (e *Engine) recordSync(trigger Trigger, startedAt time.Time, result *SyncResult, err error) {
+	status := e.updateStatus(trigger, startedAt, result, err)
+	if status == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), inventorySaveTimeout)
+	defer cancel()
+	if err := e.k8sClient.SaveStatus(ctx, e.namespace, e.name, status); err != nil {
+		log.Errorf("Error saving status of %s: %v", e.name, err)
+	}
+}
+
+func (e *Engine) updateStatus(trigger Trigger, startedAt time.Time, result *SyncResult, err error) *k8s.SyncStatus {
+	record := result
+	if record == nil {
+		record = &SyncResult{Status: SyncStatusFailure, Errors: []error{err}}
+	}
+	record.Trigger = trigger
+	record.StartedAt = startedAt
+	record.FinishedAt = time.Now()
+
+	e.statusMu.Lock()
+	defer e.statusMu.Unlock()
+
+	e.recentSyncs = append(e.recentSyncs, record)
+	if len(e.recentSyncs) > maxRecentSyncs {
+		e.recentSyncs = e.recentSyncs[len(e.recentSyncs)-maxRecentSyncs:]
+	}
+	if record.DryRun {
+		return nil
+	}
+	e.attempted = true
+	e.recordedAt = record.FinishedAt
+	if record.Status == SyncStatusSuccess {
+		e.lastSuccess = record.FinishedAt
+	}
+	e.syncedSHA = e.lastSyncedSHA
+	if result != nil && !result.Skipped {
+		e.drift = result.Drift
+		e.driftReasons = result.DriftReasons
+	}
+
+	status := &k8s.SyncStatus{
+		Commit:       record.CommitSHA,
+		Ref:          record.Ref,
+		Trigger:      string(record.Trigger),
+		Status:       record.Status,
+		StartedAt:    record.StartedAt,
+		FinishedAt:   record.FinishedAt,
+		SyncedCommit: e.syncedSHA,
+		LastSuccess:  e.lastSuccess,
+		Drift:        e.drift,
+		DriftReasons: e.driftReasons,
+	}
+	for _, err := range record.Errors {
+		status.Errors = append(status.Errors, err.Error())
+	}
+	e.saved = status
+	return status
+}
+
+func (e *Engine) Status() EngineStatus {
+	e.statusMu.RLock()
+	defer e.statusMu.RUnlock()
+
+	status := EngineStatus{
+		Name:          e.name,
+		URL:           e.gitRepo.DisplayURL(),
+		Ref:           e.gitRepo.TrackedRef(),
+		Namespace:     e.namespace,
+		Path:          e.repoPath,
+		Suspended:     e.Suspended(),
+		LastSyncedSHA: e.syncedSHA,
+		LastSyncTime:  e.lastSuccess,
+		Drift:         e.drift,
+		DriftReasons:  e.driftReasons,
+		Cloned:        e.cloned.Load(),
+		Cloning:       e.cloning.Load(),
+		CloneError:    e.cloneErr,
+		SyncAttempted: e.attempted,
+		BusySince:     e.busySince,
+	}
+	if n := len(e.recentSyncs); n > 0 {
+		status.LastResult = e.recentSyncs[n-1]
+	}
+
+	// Report a sync run by another replica, e.g. on a follower.
+	if saved := e.saved; saved != nil && saved.FinishedAt.After(e.recordedAt) {
+		status.LastSyncedSHA = saved.SyncedCommit
+		status.LastSyncTime = saved.LastSuccess
+		status.Drift = saved.Drift
+		status.DriftReasons = saved.DriftReasons
+		status.LastResult = savedResult(saved)
+	}
+	return status
+}
+
+func savedResult(saved *k8s.SyncStatus) *SyncResult {
+	result := &SyncResult{
+		CommitSHA:    saved.Commit,
+		Ref:          saved.Ref,
+		Trigger:      Trigger(saved.Trigger),
+		Status:       saved.Status,
+		StartedAt:    saved.StartedAt,
+		FinishedAt:   saved.FinishedAt,
+		Drift:        saved.Drift,
+		DriftReasons: saved.DriftReasons,
+	}
+	for _, err := range saved.Errors {
+		result.Errors = append(result.Errors, errors.New(err))
+	}
+	return result
+}
+
+// RecentSyncs returns up to limit results, newest first, skipping the first
+// offset, together with the total number held.
+func (e *Engine) RecentSyncs(offset, limit int) ([]*SyncResult, int) {
+	e.statusMu.RLock()
+	defer e.statusMu.RUnlock()
+
+	total := len(e.recentSyncs)
+	var results []*SyncResult
+	for i := total - 1 - offset; i >= 0 && len(results) < limit; i-- {
+		results = append(results, e.recentSyncs[i])
+	}
+	return results, total
+}